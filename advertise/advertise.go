@@ -1,6 +1,7 @@
 package advertise
 
 import (
+	"context"
 	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/constants"
@@ -13,7 +14,28 @@ import (
 	"strings"
 )
 
-func Routes(states []*state.State) (err error) {
+func providerName() string {
+	switch config.Config.Provider {
+	case "aws":
+		return "AWS"
+	case "google":
+		return "Google"
+	case "oracle":
+		return "Oracle"
+	case "unifi":
+		return "Unifi"
+	default:
+		return "Provider"
+	}
+}
+
+// Routes are removed from the provider when delete routes is enabled or
+// while draining, otherwise only the local route record is removed
+func deleteRoutes() bool {
+	return config.Config.DeleteRoutes || config.Config.Drain
+}
+
+func Routes(ctx context.Context, states []*state.State) (err error) {
 	if constants.Interrupt {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "advertise: Interrupt"),
@@ -21,17 +43,29 @@ func Routes(states []*state.State) (err error) {
 		return
 	}
 
+	defer func() {
+		if err != nil {
+			state.SetError("advertise_routes", state.SeverityError,
+				providerName()+" route table update failed", err)
+		} else {
+			state.ClearError("advertise_routes")
+		}
+	}()
+
 	networks := []string{}
 
-	for _, stat := range states {
-		if stat.Type == state.DirectClient ||
-			stat.Type == state.DirectServer {
+	// Draining host withdraws all routes to let peers take over
+	if !config.Config.Drain {
+		for _, stat := range states {
+			if stat.Type == state.DirectClient ||
+				stat.Type == state.DirectServer {
 
-			continue
-		}
-		for _, link := range stat.Links {
-			for _, network := range link.RightSubnets {
-				networks = append(networks, network)
+				continue
+			}
+			for _, link := range stat.Links {
+				for _, network := range link.RightSubnets {
+					networks = append(networks, network)
+				}
 			}
 		}
 	}
@@ -45,7 +79,7 @@ func Routes(states []*state.State) (err error) {
 
 	if curRoutes.Google != nil {
 		for _, route := range curRoutes.Google {
-			err = GoogleDeleteRoute(route)
+			err = GoogleDeleteRoute(ctx, route)
 			if err != nil {
 				return
 			}
@@ -54,7 +88,7 @@ func Routes(states []*state.State) (err error) {
 
 	if curRoutes.Oracle != nil {
 		for _, route := range curRoutes.Oracle {
-			err = OracleDeleteRoute(route)
+			err = OracleDeleteRoute(ctx, route)
 			if err != nil {
 				return
 			}
@@ -63,7 +97,7 @@ func Routes(states []*state.State) (err error) {
 
 	if curRoutes.Aws != nil {
 		for _, route := range curRoutes.Aws {
-			err = AwsDeleteRoute(route)
+			err = AwsDeleteRoute(ctx, route)
 			if err != nil {
 				return
 			}
@@ -72,7 +106,7 @@ func Routes(states []*state.State) (err error) {
 
 	if curRoutes.Unifi != nil {
 		for _, route := range curRoutes.Unifi {
-			err = UnifiDeleteRoute(route)
+			err = UnifiDeleteRoute(ctx, route)
 			if err != nil {
 				return
 			}
@@ -82,28 +116,28 @@ func Routes(states []*state.State) (err error) {
 	for _, network := range networks {
 		switch config.Config.Provider {
 		case "aws":
-			err = AwsAddRoute(network)
+			err = AwsAddRoute(ctx, network)
 			if err != nil {
 				return
 			}
 
 			break
 		case "google":
-			err = GoogleAddRoute(network)
+			err = GoogleAddRoute(ctx, network)
 			if err != nil {
 				return
 			}
 
 			break
 		case "oracle":
-			err = OracleAddRoute(network)
+			err = OracleAddRoute(ctx, network)
 			if err != nil {
 				return
 			}
 
 			break
 		case "unifi":
-			err = UnifiAddRoute(network)
+			err = UnifiAddRoute(ctx, network)
 			if err != nil {
 				return
 			}
@@ -135,7 +169,7 @@ func Routes(states []*state.State) (err error) {
 	return
 }
 
-func Ports(states []*state.State) (err error) {
+func Ports(ctx context.Context, states []*state.State) (err error) {
 	if constants.Interrupt {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "advertise: Interrupt"),
@@ -143,6 +177,15 @@ func Ports(states []*state.State) (err error) {
 		return
 	}
 
+	defer func() {
+		if err != nil {
+			state.SetError("advertise_ports", state.SeverityError,
+				providerName()+" port forwarding update failed", err)
+		} else {
+			state.ClearError("advertise_ports")
+		}
+	}()
+
 	hasLinks := false
 	for _, ste := range states {
 		if ste.Links != nil && len(ste.Links) != 0 {
@@ -157,7 +200,7 @@ func Ports(states []*state.State) (err error) {
 	switch config.Config.Provider {
 	case "unifi":
 		if !config.Config.Unifi.DisablePort {
-			err = UnifiAddPorts()
+			err = UnifiAddPorts(ctx)
 			if err != nil {
 				return
 			}
@@ -1,6 +1,7 @@
 package advertise
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
@@ -10,7 +11,9 @@ import (
 	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/constants"
 	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/proxy"
 	"github.com/pritunl/pritunl-link/routes"
+	"net/http"
 	"strings"
 	"time"
 )
@@ -34,10 +37,14 @@ func awsGetSession(region string) (sess *session.Session, err error) {
 		SharedConfigState: session.SharedConfigEnable,
 	}
 
+	opts.Config = aws.Config{
+		HTTPClient: &http.Client{
+			Transport: proxy.NewTransport(""),
+		},
+	}
+
 	if region != "" {
-		opts.Config = aws.Config{
-			Region: &region,
-		}
+		opts.Config.Region = &region
 	}
 
 	sess, err = session.NewSessionWithOptions(opts)
@@ -117,7 +124,7 @@ func awsGetMetaData() (data *awsMetaData, err error) {
 	return
 }
 
-func awsGetRouteTables(region, vpcId string) (
+func awsGetRouteTables(ctx context.Context, region, vpcId string) (
 	tables map[string][]*awsRoute, err error) {
 
 	tables = map[string][]*awsRoute{}
@@ -142,7 +149,7 @@ func awsGetRouteTables(region, vpcId string) (
 	input := &ec2.DescribeRouteTablesInput{}
 	input.SetFilters(filters)
 
-	vpcTables, err := ec2Svc.DescribeRouteTables(input)
+	vpcTables, err := ec2Svc.DescribeRouteTablesWithContext(ctx, input)
 	if err != nil {
 		err = &errortypes.RequestError{
 			errors.Wrap(err, "cloud: Failed to get VPC route tables"),
@@ -199,7 +206,7 @@ func awsGetRouteTables(region, vpcId string) (
 	return
 }
 
-func AwsAddRoute(network string) (err error) {
+func AwsAddRoute(ctx context.Context, network string) (err error) {
 	time.Sleep(150 * time.Millisecond)
 
 	ipv6 := strings.Contains(network, ":")
@@ -216,7 +223,7 @@ func AwsAddRoute(network string) (err error) {
 		return
 	}
 
-	tables, err := awsGetRouteTables(data.Region, data.VpcId)
+	tables, err := awsGetRouteTables(ctx, data.Region, data.VpcId)
 	if err != nil {
 		return
 	}
@@ -278,7 +285,7 @@ func AwsAddRoute(network string) (err error) {
 			}
 			input.SetRouteTableId(tableId)
 
-			_, err = ec2Svc.ReplaceRoute(input)
+			_, err = ec2Svc.ReplaceRouteWithContext(ctx, input)
 
 			if err != nil {
 				input := &ec2.CreateRouteInput{}
@@ -291,7 +298,7 @@ func AwsAddRoute(network string) (err error) {
 					input.SetInstanceId(data.InstanceId)
 				}
 
-				_, err = ec2Svc.CreateRoute(input)
+				_, err = ec2Svc.CreateRouteWithContext(ctx, input)
 				if err != nil {
 					err = &errortypes.RequestError{
 						errors.Wrap(err, "cloud: Failed to get create route"),
@@ -314,7 +321,7 @@ func AwsAddRoute(network string) (err error) {
 				input.SetInstanceId(data.InstanceId)
 			}
 
-			_, err = ec2Svc.CreateRoute(input)
+			_, err = ec2Svc.CreateRouteWithContext(ctx, input)
 			if err != nil {
 				input := &ec2.ReplaceRouteInput{}
 
@@ -331,7 +338,7 @@ func AwsAddRoute(network string) (err error) {
 				}
 				input.SetRouteTableId(tableId)
 
-				_, err = ec2Svc.ReplaceRoute(input)
+				_, err = ec2Svc.ReplaceRouteWithContext(ctx, input)
 				if err != nil {
 					err = &errortypes.RequestError{
 						errors.Wrap(err, "cloud: Failed to get create route"),
@@ -358,8 +365,10 @@ func AwsAddRoute(network string) (err error) {
 	return
 }
 
-func AwsDeleteRoute(route *routes.AwsRoute) (err error) {
-	if config.Config.DeleteRoutes {
+func AwsDeleteRoute(ctx context.Context, route *routes.AwsRoute) (
+	err error) {
+
+	if deleteRoutes() {
 		time.Sleep(150 * time.Millisecond)
 
 		ipv6 := strings.Contains(route.DestNetwork, ":")
@@ -371,7 +380,7 @@ func AwsDeleteRoute(route *routes.AwsRoute) (err error) {
 			return
 		}
 
-		tables, e := awsGetRouteTables(route.Region, route.VpcId)
+		tables, e := awsGetRouteTables(ctx, route.Region, route.VpcId)
 		if e != nil {
 			err = e
 			return
@@ -395,7 +404,7 @@ func AwsDeleteRoute(route *routes.AwsRoute) (err error) {
 			}
 			input.SetRouteTableId(tableId)
 
-			ec2Svc.DeleteRoute(input)
+			ec2Svc.DeleteRouteWithContext(ctx, input)
 		}
 	}
 
@@ -406,3 +415,46 @@ func AwsDeleteRoute(route *routes.AwsRoute) (err error) {
 
 	return
 }
+
+func AwsPublicAddress() (addrs []string, err error) {
+	sess, err := awsGetSession("")
+	if err != nil {
+		return
+	}
+
+	ec2metadataSvc := ec2metadata.New(sess)
+
+	addrs = []string{}
+
+	addr, err := ec2metadataSvc.GetMetadata("public-ipv4")
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "cloud: Failed to get EC2 public address"),
+		}
+		return
+	}
+	addrs = append(addrs, addr)
+
+	// IPv6 addresses are only listed per network interface, multiple
+	// addresses are separated by newlines
+	macAddr, e := ec2metadataSvc.GetMetadata("mac")
+	if e != nil {
+		return
+	}
+
+	addrs6, e := ec2metadataSvc.GetMetadata(
+		fmt.Sprintf("network/interfaces/macs/%s/ipv6s", macAddr))
+	if e != nil {
+		return
+	}
+
+	for _, addr6 := range strings.Split(addrs6, "\n") {
+		addr6 = strings.TrimSpace(addr6)
+		if addr6 != "" {
+			addrs = append(addrs, addr6)
+			break
+		}
+	}
+
+	return
+}
@@ -7,8 +7,10 @@ import (
 	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/constants"
 	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/proxy"
 	"github.com/pritunl/pritunl-link/routes"
 	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/compute/v1"
 	"io/ioutil"
@@ -139,13 +141,14 @@ func googleGetMetaData() (data *googleMetaData, err error) {
 	return
 }
 
-func googleGetRoutes(svc *compute.Service, project string) (
+func googleGetRoutes(ctx context.Context, svc *compute.Service,
+	project string) (
 	routes map[string]*googleRoute, err error) {
 
 	routes = map[string]*googleRoute{}
 	call := svc.Routes.List(project)
 
-	resp, err := call.Do()
+	resp, err := call.Context(ctx).Do()
 	if err != nil {
 		err = &errortypes.RequestError{
 			errors.Wrap(err, "advertise: Failed to get Google routes"),
@@ -170,10 +173,10 @@ func googleGetRoutes(svc *compute.Service, project string) (
 	return
 }
 
-func googleHasRoute(svc *compute.Service, project, destRange,
-	networkShort, instanceShort string) (exists bool, err error) {
+func googleHasRoute(ctx context.Context, svc *compute.Service, project,
+	destRange, networkShort, instanceShort string) (exists bool, err error) {
 
-	rotes, err := googleGetRoutes(svc, project)
+	rotes, err := googleGetRoutes(ctx, svc, project)
 	if err != nil {
 		return
 	}
@@ -185,7 +188,7 @@ func googleHasRoute(svc *compute.Service, project, destRange,
 
 			call := svc.Routes.Delete(project, route.Name)
 
-			_, err = call.Do()
+			_, err = call.Context(ctx).Do()
 			if err != nil {
 				err = &errortypes.RequestError{
 					errors.Wrap(err,
@@ -195,7 +198,7 @@ func googleHasRoute(svc *compute.Service, project, destRange,
 			}
 
 			for i := 0; i < 20; i++ {
-				rotes, e := googleGetRoutes(svc, project)
+				rotes, e := googleGetRoutes(ctx, svc, project)
 				if e != nil {
 					err = e
 					return
@@ -216,7 +219,7 @@ func googleHasRoute(svc *compute.Service, project, destRange,
 	return
 }
 
-func GoogleAddRoute(destNetwork string) (err error) {
+func GoogleAddRoute(ctx context.Context, destNetwork string) (err error) {
 	if constants.Interrupt {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "advertise: Interrupt"),
@@ -229,8 +232,12 @@ func GoogleAddRoute(destNetwork string) (err error) {
 		return
 	}
 
-	ctx := context.Background()
-	client, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
+	clientCtx := context.WithValue(ctx, oauth2.HTTPClient,
+		&http.Client{
+			Transport: proxy.NewTransport(""),
+		})
+	client, err := google.DefaultClient(clientCtx,
+		compute.CloudPlatformScope)
 	if err != nil {
 		err = &errortypes.RequestError{
 			errors.Wrap(err, "advertise: Failed to get Google client"),
@@ -246,7 +253,7 @@ func GoogleAddRoute(destNetwork string) (err error) {
 		return
 	}
 
-	exists, err := googleHasRoute(svc, data.Project, destNetwork,
+	exists, err := googleHasRoute(ctx, svc, data.Project, destNetwork,
 		data.NetworkShort, data.InstanceShort)
 	if err != nil {
 		return
@@ -263,7 +270,7 @@ func GoogleAddRoute(destNetwork string) (err error) {
 
 		call := svc.Routes.Insert(data.Project, googleRoute)
 
-		_, err = call.Do()
+		_, err = call.Context(ctx).Do()
 		if err != nil {
 			err = &errortypes.RequestError{
 				errors.Wrap(err, "advertise: Failed to insert Google route"),
@@ -287,8 +294,10 @@ func GoogleAddRoute(destNetwork string) (err error) {
 	return
 }
 
-func GoogleDeleteRoute(route *routes.GoogleRoute) (err error) {
-	if config.Config.DeleteRoutes {
+func GoogleDeleteRoute(ctx context.Context, route *routes.GoogleRoute) (
+	err error) {
+
+	if deleteRoutes() {
 		if constants.Interrupt {
 			err = &errortypes.UnknownError{
 				errors.Wrap(err, "advertise: Interrupt"),
@@ -296,8 +305,12 @@ func GoogleDeleteRoute(route *routes.GoogleRoute) (err error) {
 			return
 		}
 
-		ctx := context.Background()
-		client, e := google.DefaultClient(ctx, compute.CloudPlatformScope)
+		clientCtx := context.WithValue(ctx, oauth2.HTTPClient,
+			&http.Client{
+				Transport: proxy.NewTransport(""),
+			})
+		client, e := google.DefaultClient(clientCtx,
+			compute.CloudPlatformScope)
 		if e != nil {
 			err = &errortypes.RequestError{
 				errors.Wrap(e, "advertise: Failed to get Google client"),
@@ -313,7 +326,7 @@ func GoogleDeleteRoute(route *routes.GoogleRoute) (err error) {
 			return
 		}
 
-		rotes, e := googleGetRoutes(svc, route.Project)
+		rotes, e := googleGetRoutes(ctx, svc, route.Project)
 		if e != nil {
 			err = e
 			return
@@ -321,7 +334,7 @@ func GoogleDeleteRoute(route *routes.GoogleRoute) (err error) {
 
 		if rout, ok := rotes[route.DestNetwork]; ok {
 			call := svc.Routes.Delete(route.Project, rout.Name)
-			call.Do()
+			call.Context(ctx).Do()
 		}
 	}
 
@@ -332,3 +345,17 @@ func GoogleDeleteRoute(route *routes.GoogleRoute) (err error) {
 
 	return
 }
+
+func GooglePublicAddress() (addrs []string, err error) {
+	addr, err := googleInternal("computeMetadata/v1/instance/" +
+		"network-interfaces/0/access-configs/0/external-ip")
+	if err != nil {
+		return
+	}
+
+	addrs = []string{
+		strings.TrimSpace(addr),
+	}
+
+	return
+}
@@ -2,9 +2,11 @@ package advertise
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"github.com/dropbox/godropbox/errors"
@@ -12,10 +14,20 @@ import (
 	"github.com/pritunl/pritunl-link/constants"
 	"github.com/pritunl/pritunl-link/errortypes"
 	"github.com/pritunl/pritunl-link/oraclesdk"
+	"github.com/pritunl/pritunl-link/proxy"
 	"github.com/pritunl/pritunl-link/routes"
+	"net/http"
 	"time"
 )
 
+var oracleMetadataClient = &http.Client{
+	Timeout: 500 * time.Millisecond,
+}
+
+type oracleVnicMetaData struct {
+	VnicId string `json:"vnicId"`
+}
+
 func oracleParseBase64Key(data string) (pemKey []byte, fingerprint string,
 	err error) {
 
@@ -89,6 +101,7 @@ func oracleNewClient(region, privateKey, userOcid, tenancyOcid string) (
 		oraclesdk.PrivateKeyBytes(key),
 		oraclesdk.ShortRetryTime(10*time.Second),
 		oraclesdk.LongRetryTime(10*time.Second),
+		oraclesdk.CustomTransport(proxy.NewTransport("")),
 	)
 	if err != nil {
 		err = &errortypes.ParseError{
@@ -100,7 +113,7 @@ func oracleNewClient(region, privateKey, userOcid, tenancyOcid string) (
 	return
 }
 
-func OracleAddRoute(network string) (err error) {
+func OracleAddRoute(ctx context.Context, network string) (err error) {
 	time.Sleep(150 * time.Millisecond)
 
 	region := config.Config.Oracle.Region
@@ -111,7 +124,7 @@ func OracleAddRoute(network string) (err error) {
 	vncOcid := config.Config.Oracle.VncOcid
 	privateIpOcid := config.Config.Oracle.PrivateIpOcid
 
-	if constants.Interrupt {
+	if constants.Interrupt || ctx.Err() != nil {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "advertise: Interrupt"),
 		}
@@ -166,6 +179,13 @@ func OracleAddRoute(network string) (err error) {
 			routeRules = append(routeRules, route)
 		}
 
+		if ctx.Err() != nil {
+			err = &errortypes.UnknownError{
+				errors.Wrap(ctx.Err(), "advertise: Interrupt"),
+			}
+			return
+		}
+
 		opts := &oraclesdk.UpdateRouteTableOptions{
 			RouteRules: routeRules,
 		}
@@ -197,8 +217,10 @@ func OracleAddRoute(network string) (err error) {
 	return
 }
 
-func OracleDeleteRoute(oracleRoute *routes.OracleRoute) (err error) {
-	if config.Config.DeleteRoutes {
+func OracleDeleteRoute(ctx context.Context,
+	oracleRoute *routes.OracleRoute) (err error) {
+
+	if deleteRoutes() {
 		time.Sleep(150 * time.Millisecond)
 
 		region := config.Config.Oracle.Region
@@ -208,7 +230,7 @@ func OracleDeleteRoute(oracleRoute *routes.OracleRoute) (err error) {
 		compartmentOcid := config.Config.Oracle.CompartmentOcid
 		vncOcid := config.Config.Oracle.VncOcid
 
-		if constants.Interrupt {
+		if constants.Interrupt || ctx.Err() != nil {
 			err = &errortypes.UnknownError{
 				errors.Wrap(err, "advertise: Interrupt"),
 			}
@@ -251,6 +273,13 @@ func OracleDeleteRoute(oracleRoute *routes.OracleRoute) (err error) {
 				continue
 			}
 
+			if ctx.Err() != nil {
+				err = &errortypes.UnknownError{
+					errors.Wrap(ctx.Err(), "advertise: Interrupt"),
+				}
+				return
+			}
+
 			opts := &oraclesdk.UpdateRouteTableOptions{
 				RouteRules: routeRules,
 			}
@@ -272,3 +301,55 @@ func OracleDeleteRoute(oracleRoute *routes.OracleRoute) (err error) {
 
 	return
 }
+
+func OraclePublicAddress() (addrs []string, err error) {
+	resp, err := oracleMetadataClient.Get(
+		"http://169.254.169.254/opc/v1/vnics/")
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "oracle: Failed to get Oracle metadata"),
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	vnics := []*oracleVnicMetaData{}
+	err = json.NewDecoder(resp.Body).Decode(&vnics)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "oracle: Failed to parse Oracle metadata"),
+		}
+		return
+	}
+
+	if len(vnics) == 0 {
+		err = &errortypes.NotFoundError{
+			errors.New("oracle: No VNIC found in Oracle metadata"),
+		}
+		return
+	}
+
+	client, err := oracleNewClient(
+		config.Config.Oracle.Region,
+		config.Config.Oracle.PrivateKey,
+		config.Config.Oracle.UserOcid,
+		config.Config.Oracle.TenancyOcid,
+	)
+	if err != nil {
+		return
+	}
+
+	vnic, err := client.GetVnic(vnics[0].VnicId)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "oracle: Failed to get VNIC"),
+		}
+		return
+	}
+
+	addrs = []string{
+		vnic.PublicIPAddress,
+	}
+
+	return
+}
@@ -2,6 +2,7 @@ package advertise
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/tls"
 	"encoding/json"
@@ -11,6 +12,7 @@ import (
 	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/constants"
 	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/proxy"
 	"github.com/pritunl/pritunl-link/routes"
 	"github.com/pritunl/pritunl-link/state"
 	"io/ioutil"
@@ -115,7 +117,7 @@ func site() string {
 	return site
 }
 
-func unifiGetClient() (client *http.Client, err error) {
+func unifiGetClient(ctx context.Context) (client *http.Client, err error) {
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		err = &errortypes.UnknownError{
@@ -124,10 +126,9 @@ func unifiGetClient() (client *http.Client, err error) {
 		return
 	}
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+	transport := proxy.NewTransport("")
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
 	}
 
 	client = &http.Client{
@@ -170,6 +171,8 @@ func unifiGetClient() (client *http.Client, err error) {
 
 	req.Header.Set("Content-Type", "application/json")
 
+	req = req.WithContext(ctx)
+
 	resp, err := client.Do(req)
 	if err != nil {
 		err = &errortypes.RequestError{
@@ -215,7 +218,9 @@ func unifiGetClient() (client *http.Client, err error) {
 	return
 }
 
-func unifiGetRoutes(client *http.Client) (routes []*unifiRoute, err error) {
+func unifiGetRoutes(ctx context.Context, client *http.Client) (
+	routes []*unifiRoute, err error) {
+
 	req, err := http.NewRequest(
 		"GET",
 		fmt.Sprintf("%s/api/s/%s/rest/routing",
@@ -229,6 +234,8 @@ func unifiGetRoutes(client *http.Client) (routes []*unifiRoute, err error) {
 		return
 	}
 
+	req = req.WithContext(ctx)
+
 	resp, err := client.Do(req)
 	if err != nil {
 		err = &errortypes.RequestError{
@@ -292,7 +299,9 @@ func unifiGetRoutes(client *http.Client) (routes []*unifiRoute, err error) {
 	return
 }
 
-func unifiDeleteRoute(client *http.Client, id string) (err error) {
+func unifiDeleteRoute(ctx context.Context, client *http.Client,
+	id string) (err error) {
+
 	req, err := http.NewRequest(
 		"DELETE",
 		fmt.Sprintf("%s/api/s/%s/rest/routing/%s",
@@ -306,6 +315,8 @@ func unifiDeleteRoute(client *http.Client, id string) (err error) {
 		return
 	}
 
+	req = req.WithContext(ctx)
+
 	resp, err := client.Do(req)
 	if err != nil {
 		err = &errortypes.RequestError{
@@ -351,7 +362,9 @@ func unifiDeleteRoute(client *http.Client, id string) (err error) {
 	return
 }
 
-func unifiAddRoute(client *http.Client, network, nexthop string) (err error) {
+func unifiAddRoute(ctx context.Context, client *http.Client,
+	network, nexthop string) (err error) {
+
 	data := &unifiRoutingPostData{
 		Enabled: true,
 		Name: fmt.Sprintf(
@@ -384,6 +397,8 @@ func unifiAddRoute(client *http.Client, network, nexthop string) (err error) {
 		return
 	}
 
+	req = req.WithContext(ctx)
+
 	resp, err := client.Do(req)
 	if err != nil {
 		err = &errortypes.RequestError{
@@ -429,10 +444,10 @@ func unifiAddRoute(client *http.Client, network, nexthop string) (err error) {
 	return
 }
 
-func unifiHasRoute(client *http.Client, network, nexthop string) (
-	exists bool, err error) {
+func unifiHasRoute(ctx context.Context, client *http.Client,
+	network, nexthop string) (exists bool, err error) {
 
-	rts, err := unifiGetRoutes(client)
+	rts, err := unifiGetRoutes(ctx, client)
 	if err != nil {
 		return
 	}
@@ -444,7 +459,7 @@ func unifiHasRoute(client *http.Client, network, nexthop string) (
 				return
 			}
 
-			err = unifiDeleteRoute(client, route.Id)
+			err = unifiDeleteRoute(ctx, client, route.Id)
 			if err != nil {
 				return
 			}
@@ -456,7 +471,7 @@ func unifiHasRoute(client *http.Client, network, nexthop string) (
 	return
 }
 
-func UnifiAddRoute(network string) (err error) {
+func UnifiAddRoute(ctx context.Context, network string) (err error) {
 	if constants.Interrupt {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "advertise: Interrupt"),
@@ -470,18 +485,18 @@ func UnifiAddRoute(network string) (err error) {
 		return
 	}
 
-	client, err := unifiGetClient()
+	client, err := unifiGetClient(ctx)
 	if err != nil {
 		return
 	}
 
-	exists, err := unifiHasRoute(client, network, nexthop)
+	exists, err := unifiHasRoute(ctx, client, network, nexthop)
 	if err != nil {
 		return
 	}
 
 	if !exists {
-		err = unifiAddRoute(client, network, nexthop)
+		err = unifiAddRoute(ctx, client, network, nexthop)
 		if err != nil {
 			return
 		}
@@ -500,8 +515,10 @@ func UnifiAddRoute(network string) (err error) {
 	return
 }
 
-func UnifiDeleteRoute(route *routes.UnifiRoute) (err error) {
-	if config.Config.DeleteRoutes {
+func UnifiDeleteRoute(ctx context.Context, route *routes.UnifiRoute) (
+	err error) {
+
+	if deleteRoutes() {
 		if constants.Interrupt {
 			err = &errortypes.UnknownError{
 				errors.Wrap(err, "advertise: Interrupt"),
@@ -509,13 +526,13 @@ func UnifiDeleteRoute(route *routes.UnifiRoute) (err error) {
 			return
 		}
 
-		client, e := unifiGetClient()
+		client, e := unifiGetClient(ctx)
 		if e != nil {
 			err = e
 			return
 		}
 
-		rts, e := unifiGetRoutes(client)
+		rts, e := unifiGetRoutes(ctx, client)
 		if e != nil {
 			err = e
 			return
@@ -523,7 +540,7 @@ func UnifiDeleteRoute(route *routes.UnifiRoute) (err error) {
 
 		for _, rte := range rts {
 			if rte.Network == route.Network && rte.Nexthop == route.Nexthop {
-				err = unifiDeleteRoute(client, rte.Id)
+				err = unifiDeleteRoute(ctx, client, rte.Id)
 				if err != nil {
 					return
 				}
@@ -541,7 +558,7 @@ func UnifiDeleteRoute(route *routes.UnifiRoute) (err error) {
 	return
 }
 
-func unifiGetPorts(client *http.Client) (
+func unifiGetPorts(ctx context.Context, client *http.Client) (
 	ports []*unifiPortForward, err error) {
 
 	req, err := http.NewRequest(
@@ -557,6 +574,8 @@ func unifiGetPorts(client *http.Client) (
 		return
 	}
 
+	req = req.WithContext(ctx)
+
 	resp, err := client.Do(req)
 	if err != nil {
 		err = &errortypes.RequestError{
@@ -617,7 +636,9 @@ func unifiGetPorts(client *http.Client) (
 
 	return
 }
-func unifiDeletePort(client *http.Client, id string) (err error) {
+func unifiDeletePort(ctx context.Context, client *http.Client,
+	id string) (err error) {
+
 	req, err := http.NewRequest(
 		"DELETE",
 		fmt.Sprintf("%s/api/s/%s/rest/portforward/%s",
@@ -631,6 +652,8 @@ func unifiDeletePort(client *http.Client, id string) (err error) {
 		return
 	}
 
+	req = req.WithContext(ctx)
+
 	resp, err := client.Do(req)
 	if err != nil {
 		err = &errortypes.RequestError{
@@ -676,8 +699,8 @@ func unifiDeletePort(client *http.Client, id string) (err error) {
 	return
 }
 
-func unifiAddPort(client *http.Client, source, destPort,
-	forward, forwardPort, proto string) (err error) {
+func unifiAddPort(ctx context.Context, client *http.Client, source,
+	destPort, forward, forwardPort, proto string) (err error) {
 
 	data := &unifiPortPostData{
 		Name:    "Pritunl IPsec",
@@ -709,6 +732,8 @@ func unifiAddPort(client *http.Client, source, destPort,
 		return
 	}
 
+	req = req.WithContext(ctx)
+
 	resp, err := client.Do(req)
 	if err != nil {
 		err = &errortypes.RequestError{
@@ -754,8 +779,9 @@ func unifiAddPort(client *http.Client, source, destPort,
 	return
 }
 
-func unifiHasPort(client *http.Client, ports []*unifiPortForward, source,
-	destPort, forward, forwardPort, proto string) (exists bool, err error) {
+func unifiHasPort(ctx context.Context, client *http.Client,
+	ports []*unifiPortForward, source, destPort, forward, forwardPort,
+	proto string) (exists bool, err error) {
 
 	for _, port := range ports {
 		if (port.DestPort == destPort && (port.Proto == proto ||
@@ -770,7 +796,7 @@ func unifiHasPort(client *http.Client, ports []*unifiPortForward, source,
 				return
 			}
 
-			err = unifiDeletePort(client, port.Id)
+			err = unifiDeletePort(ctx, client, port.Id)
 			if err != nil {
 				return
 			}
@@ -782,7 +808,7 @@ func unifiHasPort(client *http.Client, ports []*unifiPortForward, source,
 	return
 }
 
-func UnifiAddPorts() (err error) {
+func UnifiAddPorts(ctx context.Context) (err error) {
 	if constants.Interrupt {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "advertise: Interrupt"),
@@ -798,38 +824,38 @@ func UnifiAddPorts() (err error) {
 		return
 	}
 
-	client, err := unifiGetClient()
+	client, err := unifiGetClient(ctx)
 	if err != nil {
 		return
 	}
 
-	ports, err := unifiGetPorts(client)
+	ports, err := unifiGetPorts(ctx, client)
 	if err != nil {
 		return
 	}
 
-	exists, err := unifiHasPort(client, ports, source, "500",
+	exists, err := unifiHasPort(ctx, client, ports, source, "500",
 		forward, "500", proto)
 	if err != nil {
 		return
 	}
 
 	if !exists {
-		err = unifiAddPort(client, source, "500",
+		err = unifiAddPort(ctx, client, source, "500",
 			forward, "500", proto)
 		if err != nil {
 			return
 		}
 	}
 
-	exists, err = unifiHasPort(client, ports, source, "4500",
+	exists, err = unifiHasPort(ctx, client, ports, source, "4500",
 		forward, "4500", proto)
 	if err != nil {
 		return
 	}
 
 	if !exists {
-		err = unifiAddPort(client, source, "4500",
+		err = unifiAddPort(ctx, client, source, "4500",
 			forward, "4500", proto)
 		if err != nil {
 			return
@@ -1,22 +1,18 @@
 package clean
 
 import (
-	"crypto/hmac"
-	"crypto/sha512"
-	"encoding/base64"
+	"context"
 	"fmt"
 	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/constants"
 	"github.com/pritunl/pritunl-link/errortypes"
 	"github.com/pritunl/pritunl-link/ipsec"
 	"github.com/pritunl/pritunl-link/iptables"
 	"github.com/pritunl/pritunl-link/state"
-	"github.com/pritunl/pritunl-link/utils"
 	"net/http"
 	"net/url"
-	"strconv"
-	"strings"
-	"time"
+	"sync"
 )
 
 func cleanup(uri string) (err error) {
@@ -40,34 +36,16 @@ func cleanup(uri string) (err error) {
 		return
 	}
 
-	hostId := uriData.User.Username()
-	hostSecret, _ := uriData.User.Password()
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-	nonce := utils.RandStr(32)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), constants.CleanUpTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
 
-	authStr := strings.Join([]string{
-		hostId,
-		timestamp,
-		nonce,
-		"DELETE",
-		"/link/state",
-	}, "&")
-
-	hashFunc := hmac.New(sha512.New, []byte(hostSecret))
-	hashFunc.Write([]byte(authStr))
-	rawSignature := hashFunc.Sum(nil)
-	sig := base64.StdEncoding.EncodeToString(rawSignature)
-
-	req.Header.Set("Auth-Token", hostId)
-	req.Header.Set("Auth-Timestamp", timestamp)
-	req.Header.Set("Auth-Nonce", nonce)
-	req.Header.Set("Auth-Signature", sig)
+	state.AuthRequest(req, uriData)
 
-	var client *http.Client
-	if config.Config.SkipVerify {
-		client = state.ClientInsec
-	} else {
-		client = state.ClientSec
+	client, err := state.GetClient(uri)
+	if err != nil {
+		return
 	}
 
 	res, err := client.Do(req)
@@ -83,17 +61,21 @@ func cleanup(uri string) (err error) {
 }
 
 func CleanUp() {
-	uris := config.Config.Uris
+	uris := config.Config.GetUris()
 
 	iptables.ClearIpTables()
 	ipsec.DelDirectRoute()
 	ipsec.StopTunnel()
 
+	waiter := sync.WaitGroup{}
 	for _, uri := range uris {
-		go cleanup(uri)
+		waiter.Add(1)
+		go func(uri string) {
+			defer waiter.Done()
+			cleanup(uri)
+		}(uri)
 	}
-
-	time.Sleep(3 * time.Second)
+	waiter.Wait()
 
 	return
 }
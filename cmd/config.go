@@ -23,6 +23,11 @@ func DefaultInterface(iface string) (err error) {
 func DefaultGateway(gateway string) (err error) {
 	config.Config.DefaultGateway = gateway
 
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
 	err = config.Save()
 	if err != nil {
 		return
@@ -38,6 +43,11 @@ func DefaultGateway(gateway string) (err error) {
 func LocalAddress(address string) (err error) {
 	config.Config.LocalAddress = address
 
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
 	err = config.Save()
 	if err != nil {
 		return
@@ -53,6 +63,11 @@ func LocalAddress(address string) (err error) {
 func PublicAddress(address string) (err error) {
 	config.Config.PublicAddress = address
 
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
 	err = config.Save()
 	if err != nil {
 		return
@@ -68,6 +83,11 @@ func PublicAddress(address string) (err error) {
 func Provider(provider string) (err error) {
 	config.Config.Provider = provider
 
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
 	err = config.Save()
 	if err != nil {
 		return
@@ -80,6 +100,46 @@ func Provider(provider string) (err error) {
 	return
 }
 
+func Firewall(backend string) (err error) {
+	config.Config.Firewall = backend
+
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"firewall": config.Config.Firewall,
+	}).Info("cmd.config: Set firewall backend")
+
+	return
+}
+
+func SubnetConflict(policy string) (err error) {
+	config.Config.SubnetConflict = policy
+
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"subnet_conflict": config.Config.SubnetConflict,
+	}).Info("cmd.config: Set subnet conflict policy")
+
+	return
+}
+
 func DisconnectedTimeoutOn() (err error) {
 	config.Config.DisableDisconnectedRestart = false
 
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/discover"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"strconv"
+)
+
+func DiscoverAdd(typ, value string) (err error) {
+	switch typ {
+	case discover.Http, discover.Stun, discover.Static:
+		if value == "" {
+			err = &errortypes.ParseError{
+				errors.Newf("cmd.discover: Source '%s' requires value", typ),
+			}
+			return
+		}
+		break
+	case discover.Provider:
+		value = ""
+		break
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("cmd.discover: Unknown source type '%s'", typ),
+		}
+		return
+	}
+
+	exists := false
+	for _, source := range config.Config.PublicAddressSources {
+		if source.Type == typ && source.Value == value {
+			exists = true
+		}
+	}
+
+	if !exists {
+		config.Config.PublicAddressSources = append(
+			config.Config.PublicAddressSources,
+			&config.PublicAddressSource{
+				Type:  typ,
+				Value: value,
+			},
+		)
+
+		err = config.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"type":  typ,
+		"value": value,
+	}).Info("cmd.discover: Added public address source")
+
+	return
+}
+
+func DiscoverRemove(typ, value string) (err error) {
+	exists := false
+
+	for i, source := range config.Config.PublicAddressSources {
+		if source.Type == typ && source.Value == value {
+			exists = true
+
+			config.Config.PublicAddressSources = append(
+				config.Config.PublicAddressSources[:i],
+				config.Config.PublicAddressSources[i+1:]...,
+			)
+
+			break
+		}
+	}
+
+	if exists {
+		err = config.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"type":  typ,
+		"value": value,
+	}).Info("cmd.discover: Removed public address source")
+
+	return
+}
+
+func DiscoverConsensus(consensus string) (err error) {
+	consensusInt, err := strconv.Atoi(consensus)
+	if err != nil || consensusInt < 1 {
+		err = &errortypes.ParseError{
+			errors.New("cmd.discover: Consensus must be a positive number"),
+		}
+		return
+	}
+
+	config.Config.PublicAddressConsensus = consensusInt
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"consensus": consensusInt,
+	}).Info("cmd.discover: Public address consensus set")
+
+	return
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-link/config"
+)
+
+func Drain() (err error) {
+	config.Config.Drain = true
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.drain: Host draining, links will move to peers")
+
+	return
+}
+
+func Undrain() (err error) {
+	config.Config.Drain = false
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.drain: Host undrained, accepting links")
+
+	return
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"net"
+	"strings"
+)
+
+func ExemptAdd(network string) (err error) {
+	if !strings.Contains(network, "/") {
+		network += "/32"
+	}
+
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "cmd.exempt: Failed to parse network"),
+		}
+		return
+	}
+	network = ipnet.String()
+
+	exists := false
+	for _, n := range config.Config.DirectExempt {
+		if n == network {
+			exists = true
+		}
+	}
+
+	if !exists {
+		config.Config.DirectExempt = append(
+			config.Config.DirectExempt, network)
+
+		err = config.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"direct_exempt": config.Config.DirectExempt,
+	}).Info("cmd.exempt: Added direct exempt network")
+
+	return
+}
+
+func ExemptRemove(network string) (err error) {
+	if !strings.Contains(network, "/") {
+		network += "/32"
+	}
+
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "cmd.exempt: Failed to parse network"),
+		}
+		return
+	}
+
+	exists := false
+
+	for i, n := range config.Config.DirectExempt {
+		if !strings.Contains(n, "/") {
+			n += "/32"
+		}
+
+		_, nIpnet, e := net.ParseCIDR(n)
+		if e != nil || nIpnet.String() != ipnet.String() {
+			continue
+		}
+
+		exists = true
+
+		config.Config.DirectExempt = append(
+			config.Config.DirectExempt[:i],
+			config.Config.DirectExempt[i+1:]...,
+		)
+
+		break
+	}
+
+	if exists {
+		err = config.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"direct_exempt": config.Config.DirectExempt,
+	}).Info("cmd.exempt: Removed direct exempt network")
+
+	return
+}
+
+func DefaultExemptOn() (err error) {
+	config.Config.DisableDefaultExempt = false
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.exempt: Default direct exemptions enabled")
+
+	return
+}
+
+func DefaultExemptOff() (err error) {
+	config.Config.DisableDefaultExempt = true
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.exempt: Default direct exemptions disabled")
+
+	return
+}
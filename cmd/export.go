@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/discover"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/ipsec"
+	"github.com/pritunl/pritunl-link/state"
+	"strconv"
+)
+
+func ExportPeer(stateId, link, format string) (err error) {
+	if format == "" {
+		format = ipsec.ExportStrongswan
+	}
+
+	linkIndex, err := strconv.Atoi(link)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "cmd.export: Invalid link index"),
+		}
+		return
+	}
+
+	var stat *state.State
+	for _, s := range state.GetDiskStates() {
+		if s.Id == stateId || s.Id == state.GetStaticId(stateId) {
+			stat = s
+			break
+		}
+	}
+
+	if stat == nil {
+		err = &errortypes.NotFoundError{
+			errors.New("cmd.export: State not found, server states " +
+				"are available after the service has received them"),
+		}
+		return
+	}
+
+	publicAddr := state.GetPublicAddress()
+	if publicAddr == "" {
+		publicAddr, _, err = discover.Discover(context.Background())
+		if err != nil {
+			return
+		}
+	}
+
+	output, err := ipsec.ExportPeer(stat, linkIndex, format, publicAddr)
+	if err != nil {
+		return
+	}
+
+	fmt.Print(output)
+
+	return
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"path/filepath"
+)
+
+func LocalInterface(iface string) (err error) {
+	config.Config.LocalInterface = iface
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"local_interface": config.Config.LocalInterface,
+	}).Info("cmd.local: Local interface set")
+
+	return
+}
+
+func LocalExcludeAdd(pattern string) (err error) {
+	_, err = filepath.Match(pattern, "")
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "cmd.local: Invalid interface pattern"),
+		}
+		return
+	}
+
+	exists := false
+	for _, p := range config.Config.LocalExclude {
+		if p == pattern {
+			exists = true
+		}
+	}
+
+	if !exists {
+		config.Config.LocalExclude = append(
+			config.Config.LocalExclude, pattern)
+
+		err = config.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"local_exclude": config.Config.LocalExclude,
+	}).Info("cmd.local: Added local exclude pattern")
+
+	return
+}
+
+func LocalExcludeRemove(pattern string) (err error) {
+	exists := false
+
+	for i, p := range config.Config.LocalExclude {
+		if p == pattern {
+			exists = true
+
+			config.Config.LocalExclude = append(
+				config.Config.LocalExclude[:i],
+				config.Config.LocalExclude[i+1:]...,
+			)
+
+			break
+		}
+	}
+
+	if exists {
+		err = config.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"local_exclude": config.Config.LocalExclude,
+	}).Info("cmd.local: Removed local exclude pattern")
+
+	return
+}
+
+func DefaultLocalExcludeOn() (err error) {
+	config.Config.DisableDefaultLocalExclude = false
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.local: Default local exclude patterns enabled")
+
+	return
+}
+
+func DefaultLocalExcludeOff() (err error) {
+	config.Config.DisableDefaultLocalExclude = true
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.local: Default local exclude patterns disabled")
+
+	return
+}
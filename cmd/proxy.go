@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/proxy"
+	"strings"
+)
+
+func redactProxy(prxy string) string {
+	if prxy == "" || prxy == proxy.Direct {
+		return prxy
+	}
+
+	proxyUrl, err := proxy.Parse(prxy)
+	if err != nil {
+		return ""
+	}
+
+	return proxyUrl.Redacted()
+}
+
+func Proxy(prxy string) (err error) {
+	prxy = strings.TrimSpace(prxy)
+
+	if prxy != "" && prxy != proxy.Direct {
+		_, err = proxy.Parse(prxy)
+		if err != nil {
+			return
+		}
+	}
+
+	config.Config.Proxy = prxy
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"proxy": redactProxy(prxy),
+	}).Info("cmd.proxy: Set proxy")
+
+	return
+}
+
+func UriProxy(uri, prxy string) (err error) {
+	prxy = strings.TrimSpace(prxy)
+
+	uriData, err := config.Config.FindUri(uri)
+	if err != nil {
+		return
+	}
+
+	if prxy != "" && prxy != proxy.Direct {
+		_, err = proxy.Parse(prxy)
+		if err != nil {
+			return
+		}
+	}
+
+	uriData.Proxy = prxy
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"proxy": redactProxy(prxy),
+	}).Info("cmd.proxy: Set URI proxy")
+
+	return
+}
+
+func NoProxyAdd(host string) (err error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return
+	}
+
+	exists := false
+	for _, h := range config.Config.NoProxy {
+		if h == host {
+			exists = true
+		}
+	}
+
+	if !exists {
+		config.Config.NoProxy = append(config.Config.NoProxy, host)
+
+		err = config.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"no_proxy": config.Config.NoProxy,
+	}).Info("cmd.proxy: Added no proxy host")
+
+	return
+}
+
+func NoProxyRemove(host string) (err error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+
+	exists := false
+
+	for i, h := range config.Config.NoProxy {
+		if h == host {
+			exists = true
+
+			config.Config.NoProxy = append(
+				config.Config.NoProxy[:i],
+				config.Config.NoProxy[i+1:]...,
+			)
+
+			break
+		}
+	}
+
+	if exists {
+		err = config.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"no_proxy": config.Config.NoProxy,
+	}).Info("cmd.proxy: Removed no proxy host")
+
+	return
+}
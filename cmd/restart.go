@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/clean"
+	"github.com/pritunl/pritunl-link/constants"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/utils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func writeRestart() (err error) {
+	data := strconv.FormatInt(time.Now().Unix(), 10)
+
+	err = ioutil.WriteFile(constants.RestartPath, []byte(data), 0600)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "cmd.restart: Failed to write restart marker"),
+		}
+		return
+	}
+
+	return
+}
+
+// Check and remove restart marker left by a graceful stop
+func readRestart() (graceful bool) {
+	data, err := ioutil.ReadFile(constants.RestartPath)
+	if err != nil {
+		return
+	}
+	os.Remove(constants.RestartPath)
+
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return
+	}
+
+	if time.Since(time.Unix(timestamp, 0)) > constants.GracefulRestartTimeout {
+		logrus.Warn("cmd.restart: Restart marker expired, " +
+			"rebuilding links")
+		return
+	}
+
+	graceful = true
+
+	return
+}
+
+func writePid() (err error) {
+	data := strconv.Itoa(os.Getpid())
+
+	err = ioutil.WriteFile(constants.PidPath, []byte(data), 0600)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "cmd.restart: Failed to write pid file"),
+		}
+		return
+	}
+
+	return
+}
+
+func removePid() {
+	os.Remove(constants.PidPath)
+}
+
+// Start the service after a graceful stop, the service exits cleanly
+// with links kept and is not restarted by the service manager
+func startService() (err error) {
+	exists, err := utils.ExistsDir(constants.SystemdPath)
+	if err != nil {
+		return
+	}
+
+	if exists {
+		err = utils.Exec("", "systemctl", "start", constants.ServiceName)
+		if err != nil {
+			return
+		}
+
+		return
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		err = &errortypes.ExecError{
+			errors.Wrap(err, "cmd.restart: Failed to get executable path"),
+		}
+		return
+	}
+
+	proc := exec.Command(exe, "start")
+	proc.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+
+	err = proc.Start()
+	if err != nil {
+		err = &errortypes.ExecError{
+			errors.Wrap(err, "cmd.restart: Failed to start service"),
+		}
+		return
+	}
+
+	proc.Process.Release()
+
+	return
+}
+
+// Restart the running service with links kept up, package upgrades must
+// use this instead of restarting the service with the service manager
+// which stops the service with SIGTERM and tears down all links
+func Restart() (err error) {
+	data, err := ioutil.ReadFile(constants.PidPath)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "cmd.restart: Failed to read pid file"),
+		}
+		return
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "cmd.restart: Failed to parse pid file"),
+		}
+		return
+	}
+
+	err = syscall.Kill(pid, syscall.SIGUSR2)
+	if err != nil {
+		err = &errortypes.ExecError{
+			errors.Wrap(err, "cmd.restart: Failed to signal service"),
+		}
+		return
+	}
+
+	start := time.Now()
+	for syscall.Kill(pid, 0) == nil {
+		if time.Since(start) > constants.ShutdownTimeout+5*time.Second {
+			err = &errortypes.ExecError{
+				errors.New("cmd.restart: Timed out waiting for service"),
+			}
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	logrus.Info("cmd.restart: Service stopped with links kept for restart")
+
+	err = startService()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.restart: Service started reusing existing links")
+
+	return
+}
+
+func CleanUp() (err error) {
+	os.Remove(constants.RestartPath)
+
+	clean.CleanUp()
+
+	logrus.Info("cmd.restart: Links cleaned up")
+
+	return
+}
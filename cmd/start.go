@@ -1,34 +1,120 @@
 package cmd
 
 import (
+	"context"
 	"github.com/Sirupsen/logrus"
 	"github.com/pritunl/pritunl-link/clean"
+	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/constants"
+	"github.com/pritunl/pritunl-link/ipsec"
+	"github.com/pritunl/pritunl-link/state"
+	"github.com/pritunl/pritunl-link/supervisor"
 	"github.com/pritunl/pritunl-link/sync"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 )
 
+// Run link until the context is done then stop all background tasks, with
+// graceful set links left by a previous process are reused
+func Run(ctx context.Context, graceful bool) (err error) {
+	sup := supervisor.New(ctx)
+
+	ipsec.Start(sup, graceful)
+	sync.Start(sup)
+
+	<-ctx.Done()
+
+	constants.Interrupt = true
+
+	err = sup.Stop(constants.ShutdownTimeout)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
 func Start() (err error) {
+	// Configuration is loaded without validation for all commands, only
+	// the service refuses to run with an invalid configuration
+	err = config.Config.Validate()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("cmd.start: Invalid configuration, refusing to start")
+		os.Exit(1)
+	}
+
+	graceful := readRestart()
+
 	logrus.WithFields(logrus.Fields{
-		"version": constants.Version,
+		"version":  constants.Version,
+		"graceful": graceful,
 	}).Info("cmd.start: Starting link")
 
-	sync.Init()
+	state.CleanDiskCache()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = writePid()
+	if err != nil {
+		return
+	}
+	defer removePid()
+
+	// SIGUSR2 from the restart command stops without teardown, SIGTERM
+	// and SIGINT always tear down links
+	keepLinks := false
 
 	sig := make(chan os.Signal, 2)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-	<-sig
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP,
+		syscall.SIGUSR2)
 
-	constants.Interrupt = true
+	go func() {
+		for s := range sig {
+			switch s {
+			case syscall.SIGHUP:
+				select {
+				case sync.Reload <- true:
+				default:
+				}
+				continue
+			case syscall.SIGUSR2:
+				keepLinks = true
+				break
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"keep_links": keepLinks,
+			}).Info("cmd.start: Stopping link")
+
+			cancel()
+			return
+		}
+	}()
 
-	clean.CleanUp()
+	err = Run(ctx, graceful)
+	if err != nil {
+		// Tasks still running are interrupted and stop before their next
+		// change, an explicit stop must always remove the links
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("cmd.start: Failed to stop background tasks")
+		err = nil
+	}
 
-	time.Sleep(1010 * time.Millisecond)
+	if keepLinks {
+		err = writeRestart()
+		if err != nil {
+			return
+		}
 
-	clean.CleanUp()
+		logrus.Info("cmd.start: Stopped with links kept for restart")
+	} else {
+		clean.CleanUp()
+	}
 
 	return
 }
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"strings"
+)
+
+func splitSubnets(subnets string) (networks []string) {
+	networks = []string{}
+
+	for _, network := range strings.Split(subnets, ",") {
+		network = strings.TrimSpace(network)
+		if network != "" {
+			networks = append(networks, network)
+		}
+	}
+
+	return
+}
+
+func StaticAdd(name, right, pskPath, leftSubnets,
+	rightSubnets string) (err error) {
+
+	link := config.Config.GetStaticLink(name)
+	if link == nil {
+		link = &config.StaticLinkData{
+			Name: name,
+		}
+		config.Config.StaticLinks = append(config.Config.StaticLinks, link)
+	}
+
+	link.Right = right
+	link.PskPath = pskPath
+	link.LeftSubnets = splitSubnets(leftSubnets)
+	link.RightSubnets = splitSubnets(rightSubnets)
+
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"name":          link.Name,
+		"right":         link.Right,
+		"left_subnets":  link.LeftSubnets,
+		"right_subnets": link.RightSubnets,
+	}).Info("cmd.static: Set static link")
+
+	return
+}
+
+func StaticRemove(name string) (err error) {
+	links := []*config.StaticLinkData{}
+	for _, link := range config.Config.StaticLinks {
+		if link.Name != name {
+			links = append(links, link)
+		}
+	}
+	config.Config.StaticLinks = links
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"name": name,
+	}).Info("cmd.static: Removed static link")
+
+	return
+}
+
+func StaticOption(name, key, val string) (err error) {
+	link := config.Config.GetStaticLink(name)
+	if link == nil {
+		err = &errortypes.NotFoundError{
+			errors.New("cmd.static: Static link has not been added"),
+		}
+		return
+	}
+
+	switch key {
+	case "left-id":
+		link.LeftId = val
+		break
+	case "right-id":
+		link.RightId = val
+		break
+	case "keyexchange":
+		link.KeyExchange = val
+		break
+	case "ike":
+		link.Ike = val
+		break
+	case "esp":
+		link.Esp = val
+		break
+	case "enabled":
+		link.Disabled = val == "false"
+		break
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("cmd.static: Unknown static link option '%s'", key),
+		}
+		return
+	}
+
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"name":  name,
+		"key":   key,
+		"value": val,
+	}).Info("cmd.static: Set static link option")
+
+	return
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/state"
+)
+
+func getUriTls(uri string) (tlsData *config.UriTlsData, err error) {
+	uriData, err := config.Config.FindUri(uri)
+	if err != nil {
+		return
+	}
+
+	tlsData = uriData.Tls
+	if tlsData == nil {
+		tlsData = &config.UriTlsData{}
+		uriData.Tls = tlsData
+	}
+
+	return
+}
+
+func TlsPin(uri, pin string) (err error) {
+	tlsData, err := getUriTls(uri)
+	if err != nil {
+		return
+	}
+
+	if pin != "" {
+		_, err = state.ParsePin(pin)
+		if err != nil {
+			return
+		}
+	}
+
+	tlsData.Pin = pin
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"pin": pin,
+	}).Info("cmd.tls: Set server public key pin")
+
+	return
+}
+
+func TlsCa(uri, caPath string) (err error) {
+	tlsData, err := getUriTls(uri)
+	if err != nil {
+		return
+	}
+
+	tlsData.CaPath = caPath
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"ca_path": caPath,
+	}).Info("cmd.tls: Set server CA bundle")
+
+	return
+}
+
+func TlsCert(uri, certPath, keyPath string) (err error) {
+	tlsData, err := getUriTls(uri)
+	if err != nil {
+		return
+	}
+
+	tlsData.CertPath = certPath
+	tlsData.KeyPath = keyPath
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"cert_path": certPath,
+		"key_path":  keyPath,
+	}).Info("cmd.tls: Set client certificate")
+
+	return
+}
+
+func TlsClear(uri string) (err error) {
+	uriData, err := config.Config.FindUri(uri)
+	if err != nil {
+		return
+	}
+
+	uriData.Tls = nil
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.tls: Cleared server TLS settings")
+
+	return
+}
@@ -3,53 +3,62 @@ package cmd
 import (
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"os"
+	"strconv"
+	"text/tabwriter"
 )
 
+func redactedUris() (uris []string) {
+	uris = []string{}
+
+	for _, u := range config.Config.Uris {
+		uris = append(uris, u.Redacted())
+	}
+
+	return
+}
+
 func Add(uri string) (err error) {
-	exists := false
+	if uri != "" && config.Config.GetUri(uri) == nil {
+		config.Config.Uris = append(config.Config.Uris, &config.UriData{
+			Uri: uri,
+		})
 
-	if uri != "" {
-		for _, u := range config.Config.Uris {
-			if uri == u {
-				exists = true
-			}
+		err = config.Config.Validate()
+		if err != nil {
+			return
 		}
 
-		if !exists {
-			config.Config.Uris = append(config.Config.Uris, uri)
-
-			err = config.Save()
-			if err != nil {
-				return
-			}
+		err = config.Save()
+		if err != nil {
+			return
 		}
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"uris": config.Config.Uris,
+		"uris": redactedUris(),
 	}).Info("cmd.uri: Added URI")
 
 	return
 }
 
 func Remove(uri string) (err error) {
-	exists := false
-
-	for i, u := range config.Config.Uris {
-		if uri == u {
-			exists = true
-
-			config.Config.Uris = append(
-				config.Config.Uris[:i],
-				config.Config.Uris[i+1:]...,
-			)
-
-			break
+	uriData, _ := config.Config.FindUri(uri)
+
+	if uriData != nil {
+		for i, u := range config.Config.Uris {
+			if u == uriData {
+				config.Config.Uris = append(
+					config.Config.Uris[:i],
+					config.Config.Uris[i+1:]...,
+				)
+				break
+			}
 		}
-	}
 
-	if exists {
 		err = config.Save()
 		if err != nil {
 			return
@@ -57,14 +66,14 @@ func Remove(uri string) (err error) {
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"uris": config.Config.Uris,
+		"uris": redactedUris(),
 	}).Info("cmd.uri: Removed URI")
 
 	return
 }
 
 func Clear() (err error) {
-	config.Config.Uris = []string{}
+	config.Config.Uris = []*config.UriData{}
 
 	err = config.Save()
 	if err != nil {
@@ -72,15 +81,163 @@ func Clear() (err error) {
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"uris": config.Config.Uris,
+		"uris": redactedUris(),
 	}).Info("cmd.clear: Cleared URI")
 
 	return
 }
 
+func Enable(uri string) (err error) {
+	uriData, err := config.Config.FindUri(uri)
+	if err != nil {
+		return
+	}
+
+	uriData.Disabled = false
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"uri": uriData.Redacted(),
+	}).Info("cmd.uri: Enabled URI")
+
+	return
+}
+
+func Disable(uri string) (err error) {
+	uriData, err := config.Config.FindUri(uri)
+	if err != nil {
+		return
+	}
+
+	uriData.Disabled = true
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"uri": uriData.Redacted(),
+	}).Info("cmd.uri: Disabled URI")
+
+	return
+}
+
+func Label(uri, label string) (err error) {
+	uriData, err := config.Config.FindUri(uri)
+	if err != nil {
+		return
+	}
+
+	uriData.Label = label
+
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"uri":   uriData.Redacted(),
+		"label": label,
+	}).Info("cmd.uri: Set URI label")
+
+	return
+}
+
+func Tag(uri, tag string) (err error) {
+	uriData, err := config.Config.FindUri(uri)
+	if err != nil {
+		return
+	}
+
+	uriData.Tag = tag
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"uri": uriData.Redacted(),
+		"tag": tag,
+	}).Info("cmd.uri: Set URI tag")
+
+	return
+}
+
+func Timeout(uri, timeout string) (err error) {
+	uriData, err := config.Config.FindUri(uri)
+	if err != nil {
+		return
+	}
+
+	timeoutInt := 0
+	if timeout != "" {
+		timeoutInt, err = strconv.Atoi(timeout)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "cmd.uri: Failed to parse timeout"),
+			}
+			return
+		}
+	}
+
+	uriData.Timeout = timeoutInt
+
+	err = config.Config.Validate()
+	if err != nil {
+		return
+	}
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"uri":     uriData.Redacted(),
+		"timeout": timeoutInt,
+	}).Info("cmd.uri: Set URI timeout")
+
+	return
+}
+
 func List() (err error) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(writer, "LABEL\tSTATUS\tTAG\tURI")
 	for _, u := range config.Config.Uris {
-		fmt.Println(u)
+		status := "enabled"
+		if u.Disabled {
+			status = "disabled"
+		}
+
+		label := u.Label
+		if label == "" {
+			label = "-"
+		}
+
+		tag := u.Tag
+		if tag == "" {
+			tag = "-"
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n",
+			label, status, tag, u.Redacted())
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return
 	}
 
 	return
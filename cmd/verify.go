@@ -30,3 +30,29 @@ func VerifyOff() (err error) {
 
 	return
 }
+
+func LegacyCipherOn() (err error) {
+	config.Config.DisableLegacyCipher = false
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.verify: Legacy response cipher enabled")
+
+	return
+}
+
+func LegacyCipherOff() (err error) {
+	config.Config.DisableLegacyCipher = true
+
+	err = config.Save()
+	if err != nil {
+		return
+	}
+
+	logrus.Info("cmd.verify: Legacy response cipher disabled")
+
+	return
+}
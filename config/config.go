@@ -46,27 +46,63 @@ type UnifiData struct {
 	Interface   string `json:"interface"`
 }
 
+type UriTlsData struct {
+	Pin      string `json:"pin"`
+	CaPath   string `json:"ca_path"`
+	CertPath string `json:"cert_path"`
+	KeyPath  string `json:"key_path"`
+}
+
+func (t *UriTlsData) IsEmpty() bool {
+	return t == nil || (t.Pin == "" && t.CaPath == "" &&
+		t.CertPath == "" && t.KeyPath == "")
+}
+
+type PublicAddressSource struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
 type ConfigData struct {
-	loaded                     bool       `json:"-"`
-	Provider                   string     `json:"provider"`
-	DefaultInterface           string     `json:"default_interface"`
-	DefaultGateway             string     `json:"default_gateway"`
-	PublicAddress              string     `json:"public_address"`
-	LocalAddress               string     `json:"local_address"`
-	DirectSubnet               string     `json:"direct_subnet"`
-	DirectMode                 string     `json:"direct_mode"`
-	DirectSsh                  bool       `json:"direct_ssh"`
-	Address6                   string     `json:"address6"`
-	Uris                       []string   `json:"uris"`
-	SkipVerify                 bool       `json:"skip_verify"`
-	DeleteRoutes               bool       `json:"delete_routes"`
-	DisconnectedTimeout        int        `json:"disconnected_timeout"`
-	DisableAdvertiseUpdate     bool       `json:"disable_advertise_update"`
-	DisableDisconnectedRestart bool       `json:"disable_disconnected_restart"`
-	Aws                        AwsData    `json:"aws"`
-	Google                     GoogleData `json:"google"`
-	Oracle                     OracleData `json:"oracle"`
-	Unifi                      UnifiData  `json:"unifi"`
+	loaded                     bool                   `json:"-"`
+	Provider                   string                 `json:"provider"`
+	Firewall                   string                 `json:"firewall"`
+	DefaultInterface           string                 `json:"default_interface"`
+	DefaultGateway             string                 `json:"default_gateway"`
+	PublicAddress              string                 `json:"public_address"`
+	PublicAddressSources       []*PublicAddressSource `json:"public_address_sources"`
+	PublicAddressConsensus     int                    `json:"public_address_consensus"`
+	LocalAddress               string                 `json:"local_address"`
+	LocalInterface             string                 `json:"local_interface"`
+	LocalExclude               []string               `json:"local_exclude"`
+	DisableDefaultLocalExclude bool                   `json:"disable_default_local_exclude"`
+	DirectSubnet               string                 `json:"direct_subnet"`
+	DirectMode                 string                 `json:"direct_mode"`
+	DirectSsh                  bool                   `json:"direct_ssh"`
+	DirectExempt               []string               `json:"direct_exempt"`
+	DirectHoldDown             int                    `json:"direct_hold_down"`
+	DisableDefaultExempt       bool                   `json:"disable_default_exempt"`
+	SubnetConflict             string                 `json:"subnet_conflict"`
+	StaticLinks                []*StaticLinkData      `json:"static_links"`
+	Address6                   string                 `json:"address6"`
+	Uris                       []*UriData             `json:"uris"`
+	// Per uri maps from older configs, moved into uris on read
+	UriTls                     map[string]*UriTlsData `json:"uri_tls,omitempty"`
+	UriProxy                   map[string]string      `json:"uri_proxy,omitempty"`
+	Proxy                      string                 `json:"proxy"`
+	NoProxy                    []string               `json:"no_proxy"`
+	SkipVerify                 bool                   `json:"skip_verify"`
+	DisableLegacyCipher        bool                   `json:"disable_legacy_cipher"`
+	DeleteRoutes               bool                   `json:"delete_routes"`
+	DisconnectedTimeout        int                    `json:"disconnected_timeout"`
+	StateCacheMaxAge           int                    `json:"state_cache_max_age"`
+	DisableAdvertiseUpdate     bool                   `json:"disable_advertise_update"`
+	DisableDisconnectedRestart bool                   `json:"disable_disconnected_restart"`
+	Drain                      bool                   `json:"drain"`
+	Aws                        AwsData                `json:"aws"`
+	Google                     GoogleData             `json:"google"`
+	Oracle                     OracleData             `json:"oracle"`
+	Unifi                      UnifiData              `json:"unifi"`
 }
 
 func (c *ConfigData) Save() (err error) {
@@ -96,42 +132,64 @@ func (c *ConfigData) Save() (err error) {
 	return
 }
 
-func Load() (err error) {
-	data := &ConfigData{}
+func Read() (data *ConfigData, err error) {
+	data = &ConfigData{}
 
 	exists, err := utils.Exists(constants.ConfPath)
 	if err != nil {
 		return
 	}
 
-	if !exists {
-		data.loaded = true
-		Config = data
-		return
+	if exists {
+		file, e := ioutil.ReadFile(constants.ConfPath)
+		if e != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(e, "config: File read error"),
+			}
+			return
+		}
+
+		err = json.Unmarshal(file, data)
+		if err != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "config: File unmarshal error"),
+			}
+			return
+		}
 	}
 
-	file, err := ioutil.ReadFile(constants.ConfPath)
+	data.migrateUris()
+
+	data.loaded = true
+
+	return
+}
+
+func Load() (err error) {
+	data, err := Read()
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "config: File read error"),
-		}
 		return
 	}
 
-	err = json.Unmarshal(file, data)
+	Config = data
+
+	return
+}
+
+// Read and validate config file, current config is kept if the new config
+// is invalid. Returns json names of changed fields.
+func Reload() (changed []string, err error) {
+	data, err := Read()
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "config: File unmarshal error"),
-		}
 		return
 	}
 
-	if data.Uris == nil {
-		data.Uris = []string{}
+	err = data.Validate()
+	if err != nil {
+		return
 	}
 
-	data.loaded = true
-
+	changed = Diff(Config, data)
 	Config = data
 
 	return
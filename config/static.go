@@ -0,0 +1,94 @@
+package config
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"regexp"
+	"strings"
+)
+
+var staticNameRe = regexp.MustCompile("^[a-zA-Z0-9_]+$")
+
+// Link to a peer not managed by a Pritunl server such as a third party
+// IPsec gateway
+type StaticLinkData struct {
+	Name         string   `json:"name"`
+	Disabled     bool     `json:"disabled"`
+	Right        string   `json:"right"`
+	LeftId       string   `json:"left_id"`
+	RightId      string   `json:"right_id"`
+	PskPath      string   `json:"psk_path"`
+	LeftSubnets  []string `json:"left_subnets"`
+	RightSubnets []string `json:"right_subnets"`
+	KeyExchange  string   `json:"keyexchange"`
+	Ike          string   `json:"ike"`
+	Esp          string   `json:"esp"`
+}
+
+func (c *ConfigData) GetStaticLink(name string) *StaticLinkData {
+	for _, link := range c.StaticLinks {
+		if link.Name == name {
+			return link
+		}
+	}
+
+	return nil
+}
+
+func (s *StaticLinkData) Validate() (err error) {
+	if !staticNameRe.MatchString(s.Name) {
+		err = &errortypes.ParseError{
+			errors.Newf("config: Invalid static link name '%s'", s.Name),
+		}
+		return
+	}
+
+	if s.Right == "" || s.PskPath == "" {
+		err = &errortypes.ParseError{
+			errors.Newf("config: Static link '%s' missing right or psk_path",
+				s.Name),
+		}
+		return
+	}
+
+	if len(s.LeftSubnets) == 0 || len(s.RightSubnets) == 0 {
+		err = &errortypes.ParseError{
+			errors.Newf("config: Static link '%s' missing subnets", s.Name),
+		}
+		return
+	}
+
+	for _, network := range append(append([]string{}, s.LeftSubnets...),
+		s.RightSubnets...) {
+
+		err = validateNetwork("static_links", network)
+		if err != nil {
+			return
+		}
+	}
+
+	err = validateOption("static_links keyexchange", s.KeyExchange,
+		"", "ike", "ikev1", "ikev2")
+	if err != nil {
+		return
+	}
+
+	// Values are written to ipsec.conf and ipsec.secrets unescaped
+	for name, val := range map[string]string{
+		"right":    s.Right,
+		"left_id":  s.LeftId,
+		"right_id": s.RightId,
+		"ike":      s.Ike,
+		"esp":      s.Esp,
+	} {
+		if strings.ContainsAny(val, "\"\\\r\n") {
+			err = &errortypes.ParseError{
+				errors.Newf("config: Static link '%s' %s contains "+
+					"quote, backslash or newline", s.Name, name),
+			}
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"net/url"
+	"time"
+)
+
+type UriData struct {
+	Uri      string      `json:"uri"`
+	Label    string      `json:"label"`
+	Disabled bool        `json:"disabled"`
+	Tls      *UriTlsData `json:"tls"`
+	Proxy    string      `json:"proxy"`
+	Timeout  int         `json:"timeout"`
+	Tag      string      `json:"tag"`
+}
+
+// Older configs store uris as plain strings
+func (u *UriData) UnmarshalJSON(data []byte) (err error) {
+	uri := ""
+	if json.Unmarshal(data, &uri) == nil {
+		*u = UriData{
+			Uri: uri,
+		}
+		return
+	}
+
+	type uriData UriData
+	uriDt := uriData{}
+
+	err = json.Unmarshal(data, &uriDt)
+	if err != nil {
+		return
+	}
+
+	*u = UriData(uriDt)
+
+	return
+}
+
+func (u *UriData) GetTimeout() time.Duration {
+	return time.Duration(u.Timeout) * time.Second
+}
+
+// Uri with secret in userinfo replaced for display
+func (u *UriData) Redacted() string {
+	uriData, err := url.Parse(u.Uri)
+	if err != nil {
+		return "<invalid>"
+	}
+
+	if uriData.User != nil {
+		if _, ok := uriData.User.Password(); ok {
+			uriData.User = url.UserPassword(
+				uriData.User.Username(), "redacted")
+		}
+	}
+
+	return uriData.String()
+}
+
+// Find uri by full uri or label
+func (c *ConfigData) FindUri(key string) (uri *UriData, err error) {
+	if key != "" {
+		for _, u := range c.Uris {
+			if u.Uri == key {
+				uri = u
+				return
+			}
+		}
+
+		for _, u := range c.Uris {
+			if u.Label == key {
+				uri = u
+				return
+			}
+		}
+	}
+
+	err = &errortypes.NotFoundError{
+		errors.New("config: URI has not been added"),
+	}
+	return
+}
+
+func (c *ConfigData) GetUri(uri string) *UriData {
+	for _, u := range c.Uris {
+		if u.Uri == uri {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// Enabled uris used to connect to Pritunl servers
+func (c *ConfigData) GetUris() (uris []string) {
+	uris = []string{}
+
+	for _, u := range c.Uris {
+		if !u.Disabled {
+			uris = append(uris, u.Uri)
+		}
+	}
+
+	return
+}
+
+// Move per uri maps from older configs into uri objects
+func (c *ConfigData) migrateUris() {
+	uris := []*UriData{}
+	for _, u := range c.Uris {
+		if u == nil || u.Uri == "" {
+			continue
+		}
+
+		if u.Tls == nil && c.UriTls[u.Uri] != nil {
+			u.Tls = c.UriTls[u.Uri]
+		}
+		if u.Proxy == "" {
+			u.Proxy = c.UriProxy[u.Uri]
+		}
+
+		uris = append(uris, u)
+	}
+
+	c.Uris = uris
+	c.UriTls = nil
+	c.UriProxy = nil
+}
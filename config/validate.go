@@ -0,0 +1,252 @@
+package config
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+func validateAddr(name, addr string) (err error) {
+	if addr != "" && net.ParseIP(addr) == nil {
+		err = &errortypes.ParseError{
+			errors.Newf("config: Invalid %s '%s'", name, addr),
+		}
+		return
+	}
+	return
+}
+
+func validateNetwork(name, network string) (err error) {
+	if network == "" {
+		return
+	}
+
+	if !strings.Contains(network, "/") {
+		network += "/32"
+	}
+
+	_, _, err = net.ParseCIDR(network)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrapf(err, "config: Invalid %s '%s'", name, network),
+		}
+		return
+	}
+
+	return
+}
+
+// Parse proxy url, used by the proxy package which cannot be imported
+// here without an import cycle
+func ParseProxy(proxy string) (proxyUrl *url.URL, err error) {
+	proxy = strings.TrimSpace(proxy)
+
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	proxyUrl, err = url.Parse(proxy)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "config: Failed to parse proxy"),
+		}
+		return
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+		break
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("config: Unsupported proxy scheme '%s'",
+				proxyUrl.Scheme),
+		}
+		return
+	}
+
+	if proxyUrl.Host == "" {
+		err = &errortypes.ParseError{
+			errors.New("config: Proxy missing host"),
+		}
+		return
+	}
+
+	return
+}
+
+func validateProxy(name, proxy string) (err error) {
+	if proxy == "" || proxy == "direct" {
+		return
+	}
+
+	_, err = ParseProxy(proxy)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrapf(err, "config: Invalid %s", name),
+		}
+		return
+	}
+
+	return
+}
+
+func validateOption(name, val string, opts ...string) (err error) {
+	for _, opt := range opts {
+		if val == opt {
+			return
+		}
+	}
+
+	err = &errortypes.ParseError{
+		errors.Newf("config: Invalid %s '%s'", name, val),
+	}
+	return
+}
+
+func (c *ConfigData) Validate() (err error) {
+	err = validateOption("provider", c.Provider,
+		"", "aws", "google", "oracle", "unifi")
+	if err != nil {
+		return
+	}
+
+	err = validateOption("firewall", c.Firewall,
+		"", "auto", "iptables", "nftables")
+	if err != nil {
+		return
+	}
+
+	err = validateOption("direct_mode", c.DirectMode,
+		"", "gre", "vxlan", "policy")
+	if err != nil {
+		return
+	}
+
+	err = validateOption("subnet_conflict", c.SubnetConflict,
+		"", "detect", "reject", "priority", "specific")
+	if err != nil {
+		return
+	}
+
+	addrs := map[string]string{
+		"default_gateway": c.DefaultGateway,
+		"public_address":  c.PublicAddress,
+		"local_address":   c.LocalAddress,
+		"address6":        c.Address6,
+	}
+	for name, addr := range addrs {
+		err = validateAddr(name, addr)
+		if err != nil {
+			return
+		}
+	}
+
+	err = validateNetwork("direct_subnet", c.DirectSubnet)
+	if err != nil {
+		return
+	}
+
+	for _, network := range c.DirectExempt {
+		err = validateNetwork("direct_exempt", network)
+		if err != nil {
+			return
+		}
+	}
+
+	names := map[string]bool{}
+	for _, link := range c.StaticLinks {
+		err = link.Validate()
+		if err != nil {
+			return
+		}
+
+		if names[link.Name] {
+			err = &errortypes.ParseError{
+				errors.Newf("config: Duplicate static link '%s'", link.Name),
+			}
+			return
+		}
+		names[link.Name] = true
+	}
+
+	labels := map[string]bool{}
+	for _, uri := range c.Uris {
+		uriData, e := url.ParseRequestURI(uri.Uri)
+		if e != nil || uriData.Host == "" || uriData.User == nil ||
+			uriData.User.Username() == "" {
+
+			err = &errortypes.ParseError{
+				errors.New("config: Invalid uri"),
+			}
+			return
+		}
+
+		if uri.Label != "" {
+			if labels[uri.Label] {
+				err = &errortypes.ParseError{
+					errors.Newf("config: Duplicate uri label '%s'",
+						uri.Label),
+				}
+				return
+			}
+			labels[uri.Label] = true
+		}
+
+		if uri.Timeout < 0 {
+			err = &errortypes.ParseError{
+				errors.New("config: Negative uri timeout"),
+			}
+			return
+		}
+
+		err = validateProxy("uri_proxy", uri.Proxy)
+		if err != nil {
+			return
+		}
+	}
+
+	err = validateProxy("proxy", c.Proxy)
+	if err != nil {
+		return
+	}
+
+	if c.DirectHoldDown < 0 || c.DisconnectedTimeout < 0 ||
+		c.StateCacheMaxAge < 0 || c.PublicAddressConsensus < 0 {
+
+		err = &errortypes.ParseError{
+			errors.New("config: Negative duration or count"),
+		}
+		return
+	}
+
+	return
+}
+
+// Json names of top level fields that differ between configs
+func Diff(old, cur *ConfigData) (changed []string) {
+	changed = []string{}
+
+	oldVal := reflect.ValueOf(old).Elem()
+	curVal := reflect.ValueOf(cur).Elem()
+	typ := oldVal.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if !reflect.DeepEqual(oldVal.Field(i).Interface(),
+			curVal.Field(i).Interface()) {
+
+			changed = append(changed, name)
+		}
+	}
+
+	return
+}
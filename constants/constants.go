@@ -13,16 +13,40 @@ const (
 	IpsecConfPath             = "/etc/ipsec.conf"
 	IpsecSecretsPath          = "/etc/ipsec.secrets"
 	IpsecDirPath              = "/etc/ipsec.pritunl"
+	ServiceName               = "pritunl-link"
+	SystemdPath               = "/run/systemd/system"
 	PublicIpServer            = "https://app.pritunl.com/ip"
 	PublicIp6Server           = "https://app6.pritunl.com/ip"
 	DefaultDiconnectedTimeout = 60 * time.Second
 	UpdateAdvertiseRate       = 90
 	UpdateAdvertiseReplay     = 15
+	ShutdownTimeout           = 10 * time.Second
+	GracefulRestartTimeout    = 10 * time.Minute
+	CleanUpTimeout            = 3 * time.Second
+	NetworkPollRate           = 5 * time.Second
+	NetworkSlowPollRate       = 60 * time.Second
+	PublicAddressPollRate     = 30 * time.Second
 	StateCacheTtl             = 25 * time.Second
+	StateCacheRefresh         = 10 * time.Minute
+	StateRequestTimeout       = 10 * time.Second
+	StateCollectTimeout       = 3 * time.Second
+	StateBackoffMin           = 2 * time.Second
+	StateBackoffMax           = 60 * time.Second
+	StateNotifyTimeout        = 90 * time.Second
+	StateNotifyRetry          = 30 * time.Second
+	StateHeartbeatRate        = 15 * time.Second
+	StateResponseMaxAge       = 5 * time.Minute
+	StateClockSkewWarn        = 30 * time.Second
+	StateClockOffsetMax       = 15 * time.Minute
+	StateClockProvisionalMax  = 48 * time.Hour
+	DefaultStateCacheMaxAge   = 72 * time.Hour
 )
 
 var (
-	Interrupt     = false
-	RoutesPath    = path.Join(VarDir, "routes")
-	CurRoutesPath = path.Join(VarDir, "cur_routes")
+	Interrupt      = false
+	RoutesPath     = path.Join(VarDir, "routes")
+	CurRoutesPath  = path.Join(VarDir, "cur_routes")
+	StateCachePath = path.Join(VarDir, "state_cache")
+	RestartPath    = path.Join(VarDir, "restart")
+	PidPath        = path.Join(VarDir, "pritunl-link.pid")
 )
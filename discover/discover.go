@@ -0,0 +1,291 @@
+// Public address discovery from chained sources.
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/advertise"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/constants"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/proxy"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	Http     = "http"
+	Stun     = "stun"
+	Provider = "provider"
+	Static   = "static"
+)
+
+var privateNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+}
+
+var client = &http.Client{
+	Transport: proxy.NewTransport(""),
+	Timeout:   10 * time.Second,
+}
+
+type publicAddressData struct {
+	Ip string `json:"ip"`
+}
+
+type votes struct {
+	order  []string
+	counts map[string]int
+}
+
+func (v *votes) add(addr string) {
+	if _, ok := v.counts[addr]; !ok {
+		v.order = append(v.order, addr)
+	}
+	v.counts[addr] += 1
+}
+
+func (v *votes) get(consensus int) (addr string) {
+	count := 0
+	for _, a := range v.order {
+		if v.counts[a] > count {
+			addr = a
+			count = v.counts[a]
+		}
+	}
+
+	if count < consensus {
+		addr = ""
+	}
+
+	return
+}
+
+func getSources() (sources []*config.PublicAddressSource) {
+	sources = config.Config.PublicAddressSources
+	if len(sources) == 0 {
+		sources = []*config.PublicAddressSource{
+			{
+				Type:  Http,
+				Value: constants.PublicIpServer,
+			},
+			{
+				Type:  Http,
+				Value: constants.PublicIp6Server,
+			},
+		}
+	}
+	return
+}
+
+func getConsensus() int {
+	consensus := config.Config.PublicAddressConsensus
+	if consensus < 1 {
+		consensus = 1
+	}
+	return consensus
+}
+
+func httpQuery(ctx context.Context, uri string) (addrs []string, err error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "discover: Failed to create request"),
+		}
+		return
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "pritunl-link")
+
+	res, err := client.Do(req)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "discover: Failed to get public address"),
+		}
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		err = &errortypes.RequestError{
+			errors.Newf("discover: Bad status %d code from server",
+				res.StatusCode),
+		}
+		return
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "discover: Failed to read response"),
+		}
+		return
+	}
+
+	// Echo servers respond with either json or plain text
+	data := &publicAddressData{}
+	e := json.Unmarshal(body, data)
+	if e == nil && data.Ip != "" {
+		addrs = []string{data.Ip}
+	} else {
+		addrs = []string{strings.TrimSpace(string(body))}
+	}
+
+	return
+}
+
+func providerQuery() (addrs []string, err error) {
+	switch config.Config.Provider {
+	case "aws":
+		addrs, err = advertise.AwsPublicAddress()
+		break
+	case "google":
+		addrs, err = advertise.GooglePublicAddress()
+		break
+	case "oracle":
+		addrs, err = advertise.OraclePublicAddress()
+		break
+	default:
+		err = &errortypes.NotFoundError{
+			errors.New("discover: Provider does not support discovery"),
+		}
+	}
+
+	return
+}
+
+func query(ctx context.Context, source *config.PublicAddressSource) (
+	addrs []string, err error) {
+
+	switch source.Type {
+	case Http:
+		addrs, err = httpQuery(ctx, source.Value)
+		break
+	case Stun:
+		addrs, err = stunQuery(source.Value)
+		break
+	case Provider:
+		addrs, err = providerQuery()
+		break
+	case Static:
+		addrs = strings.Split(source.Value, ",")
+		break
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("discover: Unknown source type '%s'", source.Type),
+		}
+	}
+
+	return
+}
+
+func validate(addr string, static bool) (ip net.IP) {
+	ip = net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return
+	}
+
+	if static {
+		return
+	}
+
+	if !ip.IsGlobalUnicast() || isPrivate(ip) {
+		ip = nil
+		return
+	}
+
+	return
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, cidr := range privateNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Discover(ctx context.Context) (addr, addr6 string, err error) {
+	consensus := getConsensus()
+
+	votes4 := &votes{
+		counts: map[string]int{},
+	}
+	votes6 := &votes{
+		counts: map[string]int{},
+	}
+
+	for _, source := range getSources() {
+		if constants.Interrupt || ctx.Err() != nil {
+			break
+		}
+
+		addrs, e := query(ctx, source)
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"type":  source.Type,
+				"value": source.Value,
+				"error": e,
+			}).Warn("discover: Public address source failed")
+			continue
+		}
+
+		for _, a := range addrs {
+			ip := validate(a, source.Type == Static)
+			if ip == nil {
+				logrus.WithFields(logrus.Fields{
+					"type":    source.Type,
+					"value":   source.Value,
+					"address": a,
+				}).Warn("discover: Public address source returned " +
+					"invalid address")
+				continue
+			}
+
+			if ip.To4() != nil {
+				votes4.add(ip.String())
+			} else {
+				votes6.add(ip.String())
+			}
+		}
+
+		addr = votes4.get(consensus)
+		addr6 = votes6.get(consensus)
+		if addr != "" && addr6 != "" {
+			break
+		}
+	}
+
+	if addr == "" {
+		if len(votes4.order) != 0 {
+			err = &errortypes.RequestError{
+				errors.Newf("discover: Public address sources did not "+
+					"reach consensus %v", votes4.counts),
+			}
+		} else {
+			err = &errortypes.NotFoundError{
+				errors.New("discover: No public address found"),
+			}
+		}
+		return
+	}
+
+	return
+}
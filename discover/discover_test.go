@@ -0,0 +1,98 @@
+package discover
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		addr   string
+		static bool
+		valid  string
+	}{
+		{"8.8.8.8", false, "8.8.8.8"},
+		{" 8.8.8.8\n", false, "8.8.8.8"},
+		{"2001:4860:4860::8888", false, "2001:4860:4860::8888"},
+		{"10.0.0.1", false, ""},
+		{"172.16.5.1", false, ""},
+		{"192.168.1.1", false, ""},
+		{"100.64.0.1", false, ""},
+		{"fd00::1", false, ""},
+		{"127.0.0.1", false, ""},
+		{"169.254.1.1", false, ""},
+		{"0.0.0.0", false, ""},
+		{"not an address", false, ""},
+		{"", false, ""},
+		{"10.0.0.1", true, "10.0.0.1"},
+		{"not an address", true, ""},
+	}
+
+	for _, test := range tests {
+		ip := validate(test.addr, test.static)
+
+		valid := ""
+		if ip != nil {
+			valid = ip.String()
+		}
+
+		if valid != test.valid {
+			t.Errorf("validate(%q, %t) = %q, expected %q",
+				test.addr, test.static, valid, test.valid)
+		}
+	}
+}
+
+func TestVotes(t *testing.T) {
+	tests := []struct {
+		name      string
+		addrs     []string
+		consensus int
+		addr      string
+	}{
+		{
+			name:      "empty",
+			addrs:     []string{},
+			consensus: 1,
+			addr:      "",
+		},
+		{
+			name:      "single",
+			addrs:     []string{"1.1.1.1"},
+			consensus: 1,
+			addr:      "1.1.1.1",
+		},
+		{
+			name:      "majority",
+			addrs:     []string{"1.1.1.1", "2.2.2.2", "2.2.2.2"},
+			consensus: 2,
+			addr:      "2.2.2.2",
+		},
+		{
+			name:      "tie_first",
+			addrs:     []string{"1.1.1.1", "2.2.2.2"},
+			consensus: 1,
+			addr:      "1.1.1.1",
+		},
+		{
+			name:      "no_consensus",
+			addrs:     []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+			consensus: 2,
+			addr:      "",
+		},
+	}
+
+	for _, test := range tests {
+		v := &votes{
+			counts: map[string]int{},
+		}
+		for _, addr := range test.addrs {
+			v.add(addr)
+		}
+
+		addr := v.get(test.consensus)
+		if addr != test.addr {
+			t.Errorf("%s: votes got %q, expected %q",
+				test.name, addr, test.addr)
+		}
+	}
+}
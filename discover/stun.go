@@ -0,0 +1,164 @@
+package discover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/utils"
+	"net"
+	"time"
+)
+
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112a442
+	stunMappedAddr      = 0x0001
+	stunXorMappedAddr   = 0x0020
+	stunTimeout         = 3 * time.Second
+)
+
+func parseStunAddr(attr []byte, xor bool, txId []byte) (ip net.IP) {
+	if len(attr) < 8 {
+		return
+	}
+
+	family := attr[1]
+	addr := attr[4:]
+
+	switch family {
+	case 0x01:
+		if len(addr) < net.IPv4len {
+			return
+		}
+		ip = make(net.IP, net.IPv4len)
+		copy(ip, addr[:net.IPv4len])
+		break
+	case 0x02:
+		if len(addr) < net.IPv6len {
+			return
+		}
+		ip = make(net.IP, net.IPv6len)
+		copy(ip, addr[:net.IPv6len])
+		break
+	default:
+		return
+	}
+
+	if xor {
+		key := make([]byte, 16)
+		binary.BigEndian.PutUint32(key, stunMagicCookie)
+		copy(key[4:], txId)
+
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+
+	return
+}
+
+func stunQuery(server string) (addrs []string, err error) {
+	conn, err := net.DialTimeout("udp", server, stunTimeout)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "discover: Failed to connect to STUN server"),
+		}
+		return
+	}
+	defer conn.Close()
+
+	txId, err := utils.RandBytes(12)
+	if err != nil {
+		return
+	}
+
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint16(req[2:], 0)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], txId)
+
+	conn.SetDeadline(time.Now().Add(stunTimeout))
+
+	_, err = conn.Write(req)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "discover: Failed to send STUN request"),
+		}
+		return
+	}
+
+	res := make([]byte, 1500)
+	n, err := conn.Read(res)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "discover: Failed to read STUN response"),
+		}
+		return
+	}
+	res = res[:n]
+
+	if len(res) < 20 ||
+		binary.BigEndian.Uint16(res[0:]) != stunBindingResponse ||
+		binary.BigEndian.Uint32(res[4:]) != stunMagicCookie ||
+		!bytes.Equal(res[8:20], txId) {
+
+		err = &errortypes.ParseError{
+			errors.New("discover: Invalid STUN response"),
+		}
+		return
+	}
+
+	length := int(binary.BigEndian.Uint16(res[2:]))
+	if 20+length > len(res) {
+		err = &errortypes.ParseError{
+			errors.New("discover: Truncated STUN response"),
+		}
+		return
+	}
+
+	var mapped net.IP
+	var xorMapped net.IP
+
+	attrs := res[20 : 20+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+attrLen > len(attrs) {
+			break
+		}
+		attr := attrs[4 : 4+attrLen]
+
+		switch attrType {
+		case stunMappedAddr:
+			mapped = parseStunAddr(attr, false, txId)
+			break
+		case stunXorMappedAddr:
+			xorMapped = parseStunAddr(attr, true, txId)
+			break
+		}
+
+		// Attributes are padded to four bytes
+		attrLen = (attrLen + 3) &^ 3
+		if 4+attrLen > len(attrs) {
+			break
+		}
+		attrs = attrs[4+attrLen:]
+	}
+
+	addrs = []string{}
+	if xorMapped != nil {
+		addrs = append(addrs, xorMapped.String())
+	} else if mapped != nil {
+		addrs = append(addrs, mapped.String())
+	} else {
+		err = &errortypes.ParseError{
+			errors.New("discover: STUN response missing address"),
+		}
+		return
+	}
+
+	return
+}
@@ -27,3 +27,11 @@ type ExecError struct {
 type RequestError struct {
 	errors.DropboxError
 }
+
+type ExistsError struct {
+	errors.DropboxError
+}
+
+type NotFoundError struct {
+	errors.DropboxError
+}
@@ -0,0 +1,286 @@
+package ipsec
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/state"
+	"net"
+	"strings"
+)
+
+type remoteSubnet struct {
+	State   *state.State
+	Index   int
+	Link    int
+	Cidr    string
+	Network *net.IPNet
+	Drop    bool
+}
+
+type localSubnet struct {
+	Name    string
+	Network *net.IPNet
+}
+
+func getConflictPolicy() string {
+	switch config.Config.SubnetConflict {
+	case ConflictReject:
+		return ConflictReject
+	case ConflictPriority:
+		return ConflictPriority
+	case ConflictSpecific:
+		return ConflictSpecific
+	default:
+		return ConflictDetect
+	}
+}
+
+func overlaps(x, y *net.IPNet) bool {
+	return x.Contains(y.IP) || y.Contains(x.IP)
+}
+
+func prefixLen(network *net.IPNet) int {
+	ones, _ := network.Mask.Size()
+	return ones
+}
+
+func hasDirect(states []*state.State) bool {
+	for _, stat := range states {
+		if stat.Type == state.DirectClient ||
+			stat.Type == state.DirectServer {
+
+			return true
+		}
+	}
+	return false
+}
+
+func getLocalSubnets(states []*state.State) (subnets []*localSubnet) {
+	subnets = []*localSubnet{}
+
+	if hasDirect(states) {
+		directNet, err := GetDirectSubnet()
+		if err == nil {
+			subnets = append(subnets, &localSubnet{
+				Name:    "direct subnet",
+				Network: directNet,
+			})
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+
+	for _, iface := range ifaces {
+		if iface.Name == DirectIface ||
+			iface.Flags&net.FlagLoopback != 0 ||
+			iface.Flags&net.FlagUp == 0 {
+
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+
+			subnets = append(subnets, &localSubnet{
+				Name: iface.Name,
+				Network: &net.IPNet{
+					IP:   ipNet.IP.Mask(ipNet.Mask),
+					Mask: ipNet.Mask,
+				},
+			})
+		}
+	}
+
+	return
+}
+
+func getRemoteSubnets(states []*state.State) (subnets []*remoteSubnet) {
+	subnets = []*remoteSubnet{}
+
+	for i, stat := range states {
+		if stat.Type == state.DirectClient ||
+			stat.Type == state.DirectServer {
+
+			continue
+		}
+
+		for j, link := range stat.Links {
+			for _, cidr := range link.RightSubnets {
+				_, network, err := net.ParseCIDR(cidr)
+				if err != nil {
+					continue
+				}
+
+				subnets = append(subnets, &remoteSubnet{
+					State:   stat,
+					Index:   i,
+					Link:    j,
+					Cidr:    cidr,
+					Network: network,
+				})
+			}
+		}
+	}
+
+	return
+}
+
+// Remove conflicting right subnets from states using the configured
+// policy, the default detect policy only reports conflicts. States are
+// ordered by uri which is used as the priority. Links left without
+// subnets are kept to preserve link ids but not deployed.
+func resolveConflicts(states []*state.State) (resolved []*state.State) {
+	policy := getConflictPolicy()
+	remotes := getRemoteSubnets(states)
+	locals := getLocalSubnets(states)
+	conflicts := []string{}
+	dropped := false
+
+	for _, remote := range remotes {
+		for _, local := range locals {
+			if !overlaps(remote.Network, local.Network) {
+				continue
+			}
+
+			conflicts = append(conflicts, fmt.Sprintf(
+				"%s from %s overlaps %s %s",
+				remote.Cidr, remote.State.Id,
+				local.Name, local.Network.String()))
+
+			if policy == ConflictDetect {
+				continue
+			}
+
+			// Local networks have no uri priority, a more specific
+			// remote subnet is only dropped by the reject policy
+			if policy == ConflictReject ||
+				prefixLen(remote.Network) <= prefixLen(local.Network) {
+
+				remote.Drop = true
+			}
+		}
+	}
+
+	for i, remote := range remotes {
+		for _, other := range remotes[i+1:] {
+			if remote.Index == other.Index ||
+				!overlaps(remote.Network, other.Network) {
+
+				continue
+			}
+
+			conflicts = append(conflicts, fmt.Sprintf(
+				"%s from %s overlaps %s from %s",
+				remote.Cidr, remote.State.Id,
+				other.Cidr, other.State.Id))
+
+			switch policy {
+			case ConflictDetect:
+				break
+			case ConflictReject:
+				remote.Drop = true
+				other.Drop = true
+				break
+			case ConflictSpecific:
+				// Less specific subnet is dropped, equal subnets fall
+				// back to the uri priority
+				if prefixLen(remote.Network) < prefixLen(other.Network) {
+					remote.Drop = true
+				} else {
+					other.Drop = true
+				}
+				break
+			case ConflictPriority:
+				other.Drop = true
+				break
+			}
+		}
+	}
+
+	dropSubnets := map[string]bool{}
+	for _, remote := range remotes {
+		if remote.Drop {
+			dropped = true
+			dropSubnets[fmt.Sprintf("%d-%d-%s",
+				remote.Index, remote.Link, remote.Cidr)] = true
+		}
+	}
+
+	resolved = []*state.State{}
+	conflictLinks := []string{}
+
+	for i, stat := range states {
+		if !dropped {
+			resolved = append(resolved, stat)
+			continue
+		}
+
+		stateCopy := *stat
+		stateCopy.Links = []*state.Link{}
+
+		for j, link := range stat.Links {
+			linkCopy := *link
+			linkCopy.RightSubnets = []string{}
+
+			for _, cidr := range link.RightSubnets {
+				if dropSubnets[fmt.Sprintf("%d-%d-%s", i, j, cidr)] {
+					continue
+				}
+				linkCopy.RightSubnets = append(linkCopy.RightSubnets, cidr)
+			}
+
+			if len(link.RightSubnets) != 0 &&
+				len(linkCopy.RightSubnets) == 0 {
+
+				conflictLinks = append(conflictLinks,
+					fmt.Sprintf("%s-%d", stat.Id, j))
+			}
+
+			stateCopy.Links = append(stateCopy.Links, &linkCopy)
+		}
+
+		resolved = append(resolved, &stateCopy)
+	}
+
+	state.SetConflicts(conflictLinks)
+
+	if len(conflicts) == 0 {
+		state.ClearError("subnet_conflict")
+		return
+	}
+
+	severity := state.SeverityWarning
+	if dropped {
+		severity = state.SeverityError
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"policy":    policy,
+		"conflicts": conflicts,
+		"links":     conflictLinks,
+	}).Warn("ipsec: Subnet conflicts detected")
+
+	if policy == ConflictDetect {
+		state.SetError("subnet_conflict", severity, fmt.Sprintf(
+			"Subnet conflicts detected: %s",
+			strings.Join(conflicts, ", ")), nil)
+	} else {
+		state.SetError("subnet_conflict", severity, fmt.Sprintf(
+			"Subnet conflicts resolved with %s policy: %s",
+			policy, strings.Join(conflicts, ", ")), nil)
+	}
+
+	return
+}
@@ -0,0 +1,134 @@
+package ipsec
+
+import (
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/state"
+	"reflect"
+	"testing"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		subnets  [][]string
+		resolved [][]string
+	}{
+		{
+			name:   "detect",
+			policy: ConflictDetect,
+			subnets: [][]string{
+				{"198.18.0.0/16"},
+				{"198.18.1.0/24"},
+			},
+			resolved: [][]string{
+				{"198.18.0.0/16"},
+				{"198.18.1.0/24"},
+			},
+		},
+		{
+			name:   "reject",
+			policy: ConflictReject,
+			subnets: [][]string{
+				{"198.18.0.0/16", "198.19.1.0/24"},
+				{"198.18.1.0/24"},
+			},
+			resolved: [][]string{
+				{"198.19.1.0/24"},
+				{},
+			},
+		},
+		{
+			name:   "priority",
+			policy: ConflictPriority,
+			subnets: [][]string{
+				{"198.18.1.0/24"},
+				{"198.18.0.0/16"},
+			},
+			resolved: [][]string{
+				{"198.18.1.0/24"},
+				{},
+			},
+		},
+		{
+			name:   "specific_first",
+			policy: ConflictSpecific,
+			subnets: [][]string{
+				{"198.18.0.0/16"},
+				{"198.18.1.0/24"},
+			},
+			resolved: [][]string{
+				{},
+				{"198.18.1.0/24"},
+			},
+		},
+		{
+			name:   "specific_second",
+			policy: ConflictSpecific,
+			subnets: [][]string{
+				{"198.18.1.0/24"},
+				{"198.18.0.0/16"},
+			},
+			resolved: [][]string{
+				{"198.18.1.0/24"},
+				{},
+			},
+		},
+		{
+			name:   "specific_equal",
+			policy: ConflictSpecific,
+			subnets: [][]string{
+				{"198.18.1.0/24"},
+				{"198.18.1.0/24"},
+			},
+			resolved: [][]string{
+				{"198.18.1.0/24"},
+				{},
+			},
+		},
+		{
+			name:   "no_conflict",
+			policy: ConflictReject,
+			subnets: [][]string{
+				{"198.18.1.0/24"},
+				{"198.18.2.0/24"},
+			},
+			resolved: [][]string{
+				{"198.18.1.0/24"},
+				{"198.18.2.0/24"},
+			},
+		},
+	}
+
+	defer func() {
+		config.Config.SubnetConflict = ""
+	}()
+
+	for _, test := range tests {
+		config.Config.SubnetConflict = test.policy
+
+		states := []*state.State{}
+		for i, subnets := range test.subnets {
+			states = append(states, &state.State{
+				Id: string(rune('a' + i)),
+				Links: []*state.Link{
+					{
+						RightSubnets: subnets,
+					},
+				},
+			})
+		}
+
+		resolved := resolveConflicts(states)
+
+		result := [][]string{}
+		for _, stat := range resolved {
+			result = append(result, stat.Links[0].RightSubnets)
+		}
+
+		if !reflect.DeepEqual(result, test.resolved) {
+			t.Errorf("%s: resolved %v, expected %v",
+				test.name, result, test.resolved)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package ipsec
 
 import (
-	"html/template"
+	"text/template"
+	"time"
 )
 
 const (
@@ -9,16 +10,44 @@ const (
 	DirectVxlan  = "vxlan"
 	DirectPolicy = "policy"
 	DirectIface  = "pritunl0"
+	DirectTable  = 197
+
+	ConflictDetect   = "detect"
+	ConflictReject   = "reject"
+	ConflictPriority = "priority"
+	ConflictSpecific = "specific"
+
+	directPriorityExempt   = 1970
+	directPrioritySuppress = 1971
+	directPriorityTable    = 1972
+	directPriorityMax      = 1979
 
 	defaultDirectNetwork = "10.197.197.196/30"
 	defaultDirectMode    = DirectGre
-	confTemplateStr      = `conn {{.Id}}
+	exemptResolveTtl     = 60 * time.Second
+
+	defaultStaticIke = "aes256-sha256-modp2048!"
+	defaultStaticEsp = "aes256-sha256!"
+	defaultServerIke = "aes128-sha256-modp3072"
+	defaultServerEsp = "aes128-sha256"
+
+	defaultDirectHoldDown = 60 * time.Second
+	directFailTimeout     = 5 * time.Second
+	directStartHoldDown   = 30 * time.Second
+	directProbeRate       = 1 * time.Second
+	confTemplateStr       = `conn {{.Id}}
 	ikelifetime=8h
 	keylife=1h
 	rekeymargin=9m
 	keyingtries=%forever
 	authby=secret
-	keyexchange=ikev2
+	keyexchange={{.KeyExchange}}
+{{- if .Ike}}
+	ike={{.Ike}}
+{{- end}}
+{{- if .Esp}}
+	esp={{.Esp}}
+{{- end}}
 	mobike=no
 	dpddelay=5s
 	dpdtimeout=20s
@@ -36,6 +65,9 @@ const (
 )
 
 var (
+	defaultDirectExempt = []string{
+		"169.254.0.0/16",
+	}
 	confTemplate = template.Must(
 		template.New("conf").Parse(confTemplateStr))
 	secretsTemplate = template.Must(
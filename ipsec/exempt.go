@@ -0,0 +1,161 @@
+package ipsec
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/state"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type hostExempt struct {
+	Networks []string
+	Time     time.Time
+}
+
+var (
+	exemptHosts = map[string]*hostExempt{}
+)
+
+func resolveHostExempt(host string) (exempt []string) {
+	cached := exemptHosts[host]
+	if cached != nil && time.Since(cached.Time) < exemptResolveTtl {
+		exempt = cached.Networks
+		return
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"host":  host,
+			"error": err,
+		}).Warn("ipsec: Failed to resolve server host for exemption")
+
+		if cached != nil && time.Since(cached.Time) < exemptResolveTtl*10 {
+			exempt = cached.Networks
+		}
+		return
+	}
+
+	exempt = []string{}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			exempt = append(exempt, ip.String()+"/32")
+		}
+	}
+
+	exemptHosts[host] = &hostExempt{
+		Networks: exempt,
+		Time:     time.Now(),
+	}
+
+	return
+}
+
+func resolveUriExempt() (exempt []string) {
+	exempt = []string{}
+	hosts := map[string]bool{}
+
+	for _, uri := range config.Config.GetUris() {
+		uriData, err := url.ParseRequestURI(uri)
+		if err != nil {
+			continue
+		}
+
+		host := uriData.Hostname()
+
+		ip := net.ParseIP(host)
+		if ip != nil {
+			if ip.To4() != nil {
+				exempt = append(exempt, ip.String()+"/32")
+			}
+			continue
+		}
+
+		hosts[host] = true
+		exempt = append(exempt, resolveHostExempt(host)...)
+	}
+
+	for host := range exemptHosts {
+		if !hosts[host] {
+			delete(exemptHosts, host)
+		}
+	}
+
+	return
+}
+
+func getIfaceExempt() (exempt []string) {
+	exempt = []string{}
+
+	ifaceName := state.GetDefaultInterface()
+	if ifaceName == "" {
+		return
+	}
+
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			network := &net.IPNet{
+				IP:   ipnet.IP.Mask(ipnet.Mask),
+				Mask: ipnet.Mask,
+			}
+			exempt = append(exempt, network.String())
+		}
+	}
+
+	return
+}
+
+func GetDirectExempt() (exempt []string) {
+	exemptSet := map[string]bool{}
+
+	networks := []string{}
+	if !config.Config.DisableDefaultExempt {
+		networks = append(networks, defaultDirectExempt...)
+		networks = append(networks, getIfaceExempt()...)
+	}
+	networks = append(networks, config.Config.DirectExempt...)
+	networks = append(networks, resolveUriExempt()...)
+
+	for _, network := range networks {
+		if !strings.Contains(network, "/") {
+			network += "/32"
+		}
+
+		_, ipnet, err := net.ParseCIDR(network)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"network": network,
+				"error":   err,
+			}).Warn("ipsec: Invalid direct exempt network")
+			continue
+		}
+
+		if ipnet.IP.To4() == nil {
+			continue
+		}
+
+		exemptSet[ipnet.String()] = true
+	}
+
+	exempt = []string{}
+	for network := range exemptSet {
+		exempt = append(exempt, network)
+	}
+	sort.Strings(exempt)
+
+	return
+}
@@ -0,0 +1,580 @@
+package ipsec
+
+import (
+	"bytes"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/state"
+	"net"
+	"strings"
+	"text/template"
+)
+
+const (
+	ExportStrongswan = "strongswan"
+	ExportLibreswan  = "libreswan"
+	ExportVyos       = "vyos"
+	ExportMikrotik   = "mikrotik"
+	ExportCisco      = "cisco"
+
+	exportIkeLifetime = 28800
+	exportLifetime    = 3600
+)
+
+// Algorithm names for each export format, empty if not supported
+var (
+	exportEnc = map[string]map[string]string{
+		"aes128": {
+			"libreswan":    "aes128",
+			"vyos":         "aes128",
+			"mikrotik_ike": "aes-128",
+			"mikrotik_esp": "aes-128-cbc",
+			"cisco_ikev2":  "aes-cbc-128",
+			"cisco_ikev1":  "aes 128",
+			"cisco_esp":    "esp-aes 128",
+		},
+		"aes192": {
+			"libreswan":    "aes192",
+			"vyos":         "aes192",
+			"mikrotik_ike": "aes-192",
+			"mikrotik_esp": "aes-192-cbc",
+			"cisco_ikev2":  "aes-cbc-192",
+			"cisco_ikev1":  "aes 192",
+			"cisco_esp":    "esp-aes 192",
+		},
+		"aes256": {
+			"libreswan":    "aes256",
+			"vyos":         "aes256",
+			"mikrotik_ike": "aes-256",
+			"mikrotik_esp": "aes-256-cbc",
+			"cisco_ikev2":  "aes-cbc-256",
+			"cisco_ikev1":  "aes 256",
+			"cisco_esp":    "esp-aes 256",
+		},
+		"aes128gcm16": {
+			"libreswan":    "aes_gcm128",
+			"vyos":         "aes128gcm128",
+			"mikrotik_esp": "aes-128-gcm",
+			"cisco_ikev2":  "aes-gcm-128",
+			"cisco_esp":    "esp-gcm 128",
+		},
+		"aes256gcm16": {
+			"libreswan":    "aes_gcm256",
+			"vyos":         "aes256gcm128",
+			"mikrotik_esp": "aes-256-gcm",
+			"cisco_ikev2":  "aes-gcm-256",
+			"cisco_esp":    "esp-gcm 256",
+		},
+	}
+	exportInteg = map[string]map[string]string{
+		"sha1": {
+			"libreswan":    "sha1",
+			"vyos":         "sha1",
+			"mikrotik_ike": "sha1",
+			"mikrotik_esp": "sha1",
+			"cisco_ikev2":  "sha1",
+			"cisco_ikev1":  "sha",
+			"cisco_esp":    "esp-sha-hmac",
+		},
+		"sha256": {
+			"libreswan":    "sha2_256",
+			"vyos":         "sha256",
+			"mikrotik_ike": "sha256",
+			"mikrotik_esp": "sha256",
+			"cisco_ikev2":  "sha256",
+			"cisco_ikev1":  "sha256",
+			"cisco_esp":    "esp-sha256-hmac",
+		},
+		"sha384": {
+			"libreswan":   "sha2_384",
+			"vyos":        "sha384",
+			"cisco_ikev2": "sha384",
+			"cisco_ikev1": "sha384",
+			"cisco_esp":   "esp-sha384-hmac",
+		},
+		"sha512": {
+			"libreswan":    "sha2_512",
+			"vyos":         "sha512",
+			"mikrotik_ike": "sha512",
+			"mikrotik_esp": "sha512",
+			"cisco_ikev2":  "sha512",
+			"cisco_ikev1":  "sha512",
+			"cisco_esp":    "esp-sha512-hmac",
+		},
+	}
+	exportDh = map[string]string{
+		"modp1024": "2",
+		"modp1536": "5",
+		"modp2048": "14",
+		"modp3072": "15",
+		"modp4096": "16",
+		"ecp256":   "19",
+		"ecp384":   "20",
+		"ecp521":   "21",
+	}
+	exportTemplates = map[string]*template.Template{}
+)
+
+type exportProposal struct {
+	Enc   string
+	Integ string
+	Prf   string
+	Dh    string
+	Group string
+}
+
+// Integrity algorithm or prf for AEAD proposals without integrity
+func (p *exportProposal) Hash() string {
+	if p.Integ != "" {
+		return p.Integ
+	}
+	return p.Prf
+}
+
+type exportSubnet struct {
+	Cidr     string
+	Addr     string
+	Wildcard string
+}
+
+type exportPair struct {
+	Index  int
+	Local  *exportSubnet
+	Remote *exportSubnet
+}
+
+type exportData struct {
+	Name          string
+	Local         string
+	LocalId       string
+	LocalSubnets  []*exportSubnet
+	Remote        string
+	RemoteId      string
+	RemoteSubnets []*exportSubnet
+	Pairs         []*exportPair
+	PreSharedKey  string
+	Ikev1         bool
+	Ike           string
+	Esp           string
+	IkeProposal   *exportProposal
+	EspProposal   *exportProposal
+	IkeLifetime   int
+	Lifetime      int
+}
+
+// Identity type prefix used by MikroTik and Cisco
+func (d *exportData) IdType(id string) string {
+	if net.ParseIP(id) != nil {
+		return "address"
+	}
+	return "fqdn"
+}
+
+// Libreswan requires @ for identities that are not addresses
+func (d *exportData) LibreswanId(id string) string {
+	if net.ParseIP(id) != nil {
+		return id
+	}
+	return "@" + id
+}
+
+// Parse strongSwan proposal using algorithm names from column
+func parseProposal(proposal, column string, ike bool) (
+	prop *exportProposal, err error) {
+
+	prop = &exportProposal{}
+	// Only first proposal is exported
+	proposal = strings.SplitN(proposal, ",", 2)[0]
+	proposal = strings.TrimSuffix(strings.TrimSpace(proposal), "!")
+
+	for _, name := range strings.Split(proposal, "-") {
+		if names, ok := exportEnc[name]; ok {
+			prop.Enc = names[column]
+		} else if names, ok := exportInteg[name]; ok {
+			prop.Integ = names[column]
+		} else if group, ok := exportDh[name]; ok {
+			prop.Dh = name
+			prop.Group = group
+		} else if names, ok := exportInteg[strings.TrimPrefix(
+			name, "prf")]; ok && strings.HasPrefix(name, "prf") {
+
+			prop.Prf = names[column]
+			if prop.Prf == "" {
+				err = &errortypes.ParseError{
+					errors.Newf("ipsec: Algorithm '%s' not supported by %s",
+						name, column),
+				}
+				return
+			}
+		} else {
+			err = &errortypes.ParseError{
+				errors.Newf("ipsec: Unknown algorithm '%s' in proposal", name),
+			}
+			return
+		}
+
+		if prop.Enc == "" && exportEnc[name] != nil ||
+			prop.Integ == "" && exportInteg[name] != nil {
+
+			err = &errortypes.ParseError{
+				errors.Newf("ipsec: Algorithm '%s' not supported by %s",
+					name, column),
+			}
+			return
+		}
+	}
+
+	if prop.Enc == "" || (ike && prop.Dh == "") {
+		err = &errortypes.ParseError{
+			errors.Newf("ipsec: Incomplete proposal '%s'", proposal),
+		}
+		return
+	}
+
+	return
+}
+
+func getExportSubnets(subnets string) (
+	exportSubnets []*exportSubnet, err error) {
+
+	exportSubnets = []*exportSubnet{}
+
+	for _, cidr := range strings.Split(subnets, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		_, network, e := net.ParseCIDR(cidr)
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrapf(e, "ipsec: Invalid subnet '%s'", cidr),
+			}
+			return
+		}
+
+		wildcard := make(net.IP, len(network.Mask))
+		for i, b := range network.Mask {
+			wildcard[i] = ^b
+		}
+
+		exportSubnets = append(exportSubnets, &exportSubnet{
+			Cidr:     cidr,
+			Addr:     network.IP.String(),
+			Wildcard: wildcard.String(),
+		})
+	}
+
+	return
+}
+
+// Render configuration for the remote side of a link, left and right
+// are reversed from the local template data
+func ExportPeer(stat *state.State, linkIndex int, format,
+	publicAddr string) (output string, err error) {
+
+	tmpl := exportTemplates[format]
+	if tmpl == nil {
+		err = &errortypes.ParseError{
+			errors.Newf("ipsec: Unknown export format '%s'", format),
+		}
+		return
+	}
+
+	if stat.Type == state.DirectClient || stat.Type == state.DirectServer {
+		err = &errortypes.ParseError{
+			errors.New("ipsec: Direct links cannot be exported"),
+		}
+		return
+	}
+
+	if linkIndex < 0 || linkIndex >= len(stat.Links) {
+		err = &errortypes.NotFoundError{
+			errors.Newf("ipsec: Link %d not found", linkIndex),
+		}
+		return
+	}
+
+	data := getTemplateData(stat, linkIndex, publicAddr)
+
+	// Links from Pritunl servers use the strongSwan default proposals,
+	// the peer is given an explicit proposal from the defaults
+	if data.Ike == "" {
+		data.Ike = defaultServerIke
+	}
+	if data.Esp == "" {
+		data.Esp = defaultServerEsp
+	}
+
+	ike := strings.TrimSuffix(data.Ike, "!")
+	esp := strings.TrimSuffix(data.Esp, "!")
+
+	expData := &exportData{
+		Name:         data.Id,
+		Local:        data.Right,
+		LocalId:      data.RightId,
+		Remote:       data.Left,
+		RemoteId:     data.LeftId,
+		PreSharedKey: data.PreSharedKey,
+		Ikev1:        data.KeyExchange == "ikev1",
+		Ike:          ike,
+		Esp:          esp,
+		IkeLifetime:  exportIkeLifetime,
+		Lifetime:     exportLifetime,
+	}
+
+	expData.LocalSubnets, err = getExportSubnets(data.RightSubnets)
+	if err != nil {
+		return
+	}
+
+	expData.RemoteSubnets, err = getExportSubnets(data.LeftSubnets)
+	if err != nil {
+		return
+	}
+
+	expData.Pairs = []*exportPair{}
+	for _, local := range expData.LocalSubnets {
+		for _, remote := range expData.RemoteSubnets {
+			expData.Pairs = append(expData.Pairs, &exportPair{
+				Index:  len(expData.Pairs) + 1,
+				Local:  local,
+				Remote: remote,
+			})
+		}
+	}
+
+	if format != ExportStrongswan {
+		ikeColumn := format
+		espColumn := format
+		switch format {
+		case ExportMikrotik:
+			ikeColumn = "mikrotik_ike"
+			espColumn = "mikrotik_esp"
+			break
+		case ExportCisco:
+			ikeColumn = "cisco_ikev2"
+			if expData.Ikev1 {
+				ikeColumn = "cisco_ikev1"
+			}
+			espColumn = "cisco_esp"
+			break
+		}
+
+		expData.IkeProposal, err = parseProposal(ike, ikeColumn, true)
+		if err != nil {
+			return
+		}
+
+		expData.EspProposal, err = parseProposal(esp, espColumn, false)
+		if err != nil {
+			return
+		}
+
+		if format == ExportMikrotik && expData.IkeProposal.Integ == "" {
+			err = &errortypes.ParseError{
+				errors.New("ipsec: IKE proposals without integrity " +
+					"algorithm not supported by mikrotik export"),
+			}
+			return
+		}
+	}
+
+	if format == ExportCisco {
+		for _, subnets := range [][]*exportSubnet{
+			expData.LocalSubnets, expData.RemoteSubnets} {
+
+			for _, subnet := range subnets {
+				if strings.Contains(subnet.Addr, ":") {
+					err = &errortypes.ParseError{
+						errors.New("ipsec: IPv6 subnets not " +
+							"supported by cisco export"),
+					}
+					return
+				}
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, expData)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "ipsec: Failed to execute export template"),
+		}
+		return
+	}
+
+	output = buf.String()
+
+	return
+}
+
+func init() {
+	for format, tmplStr := range map[string]string{
+		ExportStrongswan: exportStrongswanTemplate,
+		ExportLibreswan:  exportLibreswanTemplate,
+		ExportVyos:       exportVyosTemplate,
+		ExportMikrotik:   exportMikrotikTemplate,
+		ExportCisco:      exportCiscoTemplate,
+	} {
+		exportTemplates[format] = template.Must(
+			template.New(format).Parse(tmplStr))
+	}
+}
+
+const exportStrongswanTemplate = `# /etc/ipsec.conf
+conn {{.Name}}
+	ikelifetime={{.IkeLifetime}}s
+	keylife={{.Lifetime}}s
+	keyingtries=%forever
+	authby=secret
+	keyexchange={{if .Ikev1}}ikev1{{else}}ikev2{{end}}
+	ike={{.Ike}}!
+	esp={{.Esp}}!
+	dpddelay=5s
+	dpdtimeout=20s
+	dpdaction=restart
+	left=%defaultroute
+	leftid={{.LocalId}}
+	leftsubnet={{range $i, $s := .LocalSubnets}}{{if $i}},{{end}}{{$s.Cidr}}{{end}}
+	right={{.Remote}}
+	rightid={{.RemoteId}}
+	rightsubnet={{range $i, $s := .RemoteSubnets}}{{if $i}},{{end}}{{$s.Cidr}}{{end}}
+	auto=start
+
+# /etc/ipsec.secrets
+{{.LocalId}} {{.RemoteId}} : PSK "{{.PreSharedKey}}"
+`
+
+const exportLibreswanTemplate = `# /etc/ipsec.d/{{.Name}}.conf
+conn {{.Name}}
+	authby=secret
+	ikev2={{if .Ikev1}}no{{else}}insist{{end}}
+	ike={{.IkeProposal.Enc}}{{with .IkeProposal.Hash}}-{{.}}{{end}};{{.IkeProposal.Dh}}
+	{{- with .EspProposal}}
+	{{- if .Integ}}
+	esp={{.Enc}}-{{.Integ}}
+	{{- else}}
+	esp={{.Enc}}
+	{{- end}}
+	{{- if .Dh}}
+	pfs=yes
+	{{- end}}
+	{{- end}}
+	ikelifetime={{.IkeLifetime}}s
+	salifetime={{.Lifetime}}s
+	dpddelay=5
+	dpdtimeout=20
+	dpdaction=restart
+	left=%defaultroute
+	leftid={{.LibreswanId .LocalId}}
+	leftsubnets={ {{- range $i, $s := .LocalSubnets}}{{if $i}} {{end}}{{$s.Cidr}}{{end -}} }
+	right={{.Remote}}
+	rightid={{.LibreswanId .RemoteId}}
+	rightsubnets={ {{- range $i, $s := .RemoteSubnets}}{{if $i}} {{end}}{{$s.Cidr}}{{end -}} }
+	auto=start
+
+# /etc/ipsec.d/{{.Name}}.secrets
+{{.LibreswanId .LocalId}} {{.LibreswanId .RemoteId}} : PSK "{{.PreSharedKey}}"
+`
+
+const exportVyosTemplate = `# VyOS 1.4
+set vpn ipsec ike-group {{.Name}} key-exchange '{{if .Ikev1}}ikev1{{else}}ikev2{{end}}'
+set vpn ipsec ike-group {{.Name}} lifetime '{{.IkeLifetime}}'
+set vpn ipsec ike-group {{.Name}} dead-peer-detection action 'restart'
+set vpn ipsec ike-group {{.Name}} dead-peer-detection interval '5'
+set vpn ipsec ike-group {{.Name}} dead-peer-detection timeout '20'
+set vpn ipsec ike-group {{.Name}} proposal 1 encryption '{{.IkeProposal.Enc}}'
+set vpn ipsec ike-group {{.Name}} proposal 1 hash '{{.IkeProposal.Hash}}'
+set vpn ipsec ike-group {{.Name}} proposal 1 dh-group '{{.IkeProposal.Group}}'
+set vpn ipsec esp-group {{.Name}} lifetime '{{.Lifetime}}'
+set vpn ipsec esp-group {{.Name}} mode 'tunnel'
+set vpn ipsec esp-group {{.Name}} pfs '{{with .EspProposal.Group}}dh-group{{.}}{{else}}disable{{end}}'
+set vpn ipsec esp-group {{.Name}} proposal 1 encryption '{{.EspProposal.Enc}}'
+{{- if .EspProposal.Integ}}
+set vpn ipsec esp-group {{.Name}} proposal 1 hash '{{.EspProposal.Integ}}'
+{{- end}}
+set vpn ipsec authentication psk {{.Name}} id '{{.LocalId}}'
+set vpn ipsec authentication psk {{.Name}} id '{{.RemoteId}}'
+set vpn ipsec authentication psk {{.Name}} secret '{{.PreSharedKey}}'
+set vpn ipsec site-to-site peer {{.Name}} authentication mode 'pre-shared-secret'
+set vpn ipsec site-to-site peer {{.Name}} authentication local-id '{{.LocalId}}'
+set vpn ipsec site-to-site peer {{.Name}} authentication remote-id '{{.RemoteId}}'
+set vpn ipsec site-to-site peer {{.Name}} connection-type 'initiate'
+set vpn ipsec site-to-site peer {{.Name}} ike-group '{{.Name}}'
+set vpn ipsec site-to-site peer {{.Name}} default-esp-group '{{.Name}}'
+set vpn ipsec site-to-site peer {{.Name}} local-address '{{.Local}}'
+set vpn ipsec site-to-site peer {{.Name}} remote-address '{{.Remote}}'
+{{- $name := .Name}}
+{{- range .Pairs}}
+set vpn ipsec site-to-site peer {{$name}} tunnel {{.Index}} local prefix '{{.Local.Cidr}}'
+set vpn ipsec site-to-site peer {{$name}} tunnel {{.Index}} remote prefix '{{.Remote.Cidr}}'
+{{- end}}
+`
+
+const exportMikrotikTemplate = `# RouterOS 7
+/ip ipsec profile add name={{.Name}} enc-algorithm={{.IkeProposal.Enc}} hash-algorithm={{.IkeProposal.Integ}} dh-group={{.IkeProposal.Dh}} lifetime={{.IkeLifetime}}s dpd-interval=5s dpd-maximum-failures=4
+/ip ipsec peer add name={{.Name}} address={{.Remote}} profile={{.Name}} exchange-mode={{if .Ikev1}}main{{else}}ike2{{end}}
+/ip ipsec proposal add name={{.Name}} enc-algorithms={{.EspProposal.Enc}} auth-algorithms={{with .EspProposal.Integ}}{{.}}{{else}}null{{end}} pfs-group={{with .EspProposal.Dh}}{{.}}{{else}}none{{end}} lifetime={{.Lifetime}}s
+/ip ipsec identity add peer={{.Name}} auth-method=pre-shared-key secret="{{.PreSharedKey}}" my-id={{.IdType .LocalId}}:{{.LocalId}} remote-id={{.IdType .RemoteId}}:{{.RemoteId}}
+{{- $name := .Name}}
+{{- range .Pairs}}
+/ip ipsec policy add peer={{$name}} tunnel=yes src-address={{.Local.Cidr}} dst-address={{.Remote.Cidr}} proposal={{$name}}
+{{- end}}
+`
+
+const exportCiscoTemplate = `! Cisco IOS
+{{- if .Ikev1}}
+crypto isakmp policy 10
+ encryption {{.IkeProposal.Enc}}
+ hash {{.IkeProposal.Integ}}
+ authentication pre-share
+ group {{.IkeProposal.Group}}
+ lifetime {{.IkeLifetime}}
+crypto isakmp key "{{.PreSharedKey}}" address {{.Remote}}
+crypto isakmp keepalive 10 5 periodic
+{{- else}}
+crypto ikev2 proposal {{.Name}}
+ encryption {{.IkeProposal.Enc}}
+{{- if .IkeProposal.Integ}}
+ integrity {{.IkeProposal.Integ}}
+{{- end}}
+{{- if .IkeProposal.Prf}}
+ prf {{.IkeProposal.Prf}}
+{{- end}}
+ group {{.IkeProposal.Group}}
+crypto ikev2 policy {{.Name}}
+ proposal {{.Name}}
+crypto ikev2 keyring {{.Name}}
+ peer {{.Name}}
+  address {{.Remote}}
+  pre-shared-key "{{.PreSharedKey}}"
+crypto ikev2 profile {{.Name}}
+ match identity remote {{.IdType .RemoteId}} {{.RemoteId}}{{if eq (.IdType .RemoteId) "address"}} 255.255.255.255{{end}}
+ identity local {{.IdType .LocalId}} {{.LocalId}}
+ authentication remote pre-share
+ authentication local pre-share
+ keyring local {{.Name}}
+ lifetime {{.IkeLifetime}}
+ dpd 10 5 periodic
+{{- end}}
+crypto ipsec transform-set {{.Name}} {{.EspProposal.Enc}}{{with .EspProposal.Integ}} {{.}}{{end}}
+ mode tunnel
+ip access-list extended {{.Name}}
+{{- range .Pairs}}
+ permit ip {{.Local.Addr}} {{.Local.Wildcard}} {{.Remote.Addr}} {{.Remote.Wildcard}}
+{{- end}}
+crypto map {{.Name}} 10 ipsec-isakmp
+ set peer {{.Remote}}
+ set transform-set {{.Name}}
+{{- with .EspProposal.Group}}
+ set pfs group{{.}}
+{{- end}}
+{{- if not .Ikev1}}
+ set ikev2-profile {{.Name}}
+{{- end}}
+ set security-association lifetime seconds {{.Lifetime}}
+ match address {{.Name}}
+! Apply to outside interface with: crypto map {{.Name}}
+`
@@ -0,0 +1,205 @@
+package ipsec
+
+import (
+	"github.com/pritunl/pritunl-link/state"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProposal(t *testing.T) {
+	tests := []struct {
+		proposal string
+		column   string
+		ike      bool
+		prop     *exportProposal
+	}{
+		{
+			proposal: "aes256-sha256-modp2048!",
+			column:   "libreswan",
+			ike:      true,
+			prop: &exportProposal{
+				Enc:   "aes256",
+				Integ: "sha2_256",
+				Dh:    "modp2048",
+				Group: "14",
+			},
+		},
+		{
+			proposal: "aes128-sha1-modp1024,aes256-sha256-modp2048",
+			column:   "cisco_ikev1",
+			ike:      true,
+			prop: &exportProposal{
+				Enc:   "aes 128",
+				Integ: "sha",
+				Dh:    "modp1024",
+				Group: "2",
+			},
+		},
+		{
+			proposal: "aes256gcm16-prfsha384-ecp384",
+			column:   "cisco_ikev2",
+			ike:      true,
+			prop: &exportProposal{
+				Enc:   "aes-gcm-256",
+				Prf:   "sha384",
+				Dh:    "ecp384",
+				Group: "20",
+			},
+		},
+		{
+			proposal: "aes256gcm16",
+			column:   "mikrotik_esp",
+			ike:      false,
+			prop: &exportProposal{
+				Enc: "aes-256-gcm",
+			},
+		},
+		{
+			proposal: "aes256-sha512-ecp256",
+			column:   "mikrotik_esp",
+			ike:      false,
+			prop: &exportProposal{
+				Enc:   "aes-256-cbc",
+				Integ: "sha512",
+				Dh:    "ecp256",
+				Group: "19",
+			},
+		},
+		{
+			proposal: "aes256gcm16-prfsha256-modp2048",
+			column:   "mikrotik_ike",
+			ike:      true,
+			prop:     nil,
+		},
+		{
+			proposal: "aes256-sha384-modp2048",
+			column:   "mikrotik_ike",
+			ike:      true,
+			prop:     nil,
+		},
+		{
+			proposal: "aes256-sha256",
+			column:   "libreswan",
+			ike:      true,
+			prop:     nil,
+		},
+		{
+			proposal: "aes256-md5-modp2048",
+			column:   "libreswan",
+			ike:      true,
+			prop:     nil,
+		},
+		{
+			proposal: "sha256-modp2048",
+			column:   "vyos",
+			ike:      true,
+			prop:     nil,
+		},
+	}
+
+	for _, test := range tests {
+		prop, err := parseProposal(test.proposal, test.column, test.ike)
+		if test.prop == nil {
+			if err == nil {
+				t.Errorf("%s %s: expected error", test.proposal, test.column)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s %s: unexpected error %s",
+				test.proposal, test.column, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(prop, test.prop) {
+			t.Errorf("%s %s: proposal %+v, expected %+v",
+				test.proposal, test.column, prop, test.prop)
+		}
+	}
+}
+
+func TestExportPeer(t *testing.T) {
+	server := &state.State{
+		Id: "server",
+		Links: []*state.Link{
+			{
+				PreSharedKey: "secret key",
+				Right:        "2.2.2.2",
+				LeftSubnets:  []string{"10.0.0.0/24"},
+				RightSubnets: []string{"10.1.0.0/24"},
+			},
+		},
+	}
+	static := &state.State{
+		Id:   "static_test",
+		Type: state.Static,
+		Links: []*state.Link{
+			{
+				PreSharedKey: "secret",
+				Right:        "2.2.2.2",
+				LeftSubnets:  []string{"10.0.0.0/24"},
+				RightSubnets: []string{"10.1.0.0/24"},
+				KeyExchange:  "ikev2",
+				Ike:          "aes256gcm16-prfsha256-modp2048!",
+				Esp:          "aes256gcm16!",
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		stat     *state.State
+		format   string
+		contains []string
+	}{
+		{
+			name:   "server_strongswan",
+			stat:   server,
+			format: ExportStrongswan,
+			contains: []string{
+				"ike=" + defaultServerIke + "!",
+				"esp=" + defaultServerEsp + "!",
+				"right=1.1.1.1",
+				"leftsubnet=10.1.0.0/24",
+			},
+		},
+		{
+			name:   "server_cisco",
+			stat:   server,
+			format: ExportCisco,
+			contains: []string{
+				"pre-shared-key \"secret key\"",
+				"encryption aes-cbc-128",
+			},
+		},
+		{
+			name:     "static_mikrotik_aead",
+			stat:     static,
+			format:   ExportMikrotik,
+			contains: nil,
+		},
+	}
+
+	for _, test := range tests {
+		output, err := ExportPeer(test.stat, 0, test.format, "1.1.1.1")
+		if test.contains == nil {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+
+		for _, str := range test.contains {
+			if !strings.Contains(output, str) {
+				t.Errorf("%s: output missing %q", test.name, str)
+			}
+		}
+	}
+}
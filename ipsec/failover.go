@@ -0,0 +1,354 @@
+package ipsec
+
+import (
+	"context"
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/state"
+	"github.com/pritunl/pritunl-link/status"
+	"github.com/pritunl/pritunl-link/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	directLock       sync.Mutex
+	directCandidates = []*state.State{}
+	directActive     = ""
+	directFailed     = map[string]time.Time{}
+	directFailSince  = time.Time{}
+	directStart      = time.Time{}
+	routesNotify     = make(chan bool, 1)
+	failoverNotify   = make(chan bool, 1)
+)
+
+// Signal the direct routes and failover tasks that the link status or
+// network state has changed
+func UpdateDirect() {
+	select {
+	case routesNotify <- true:
+	default:
+	}
+
+	select {
+	case failoverNotify <- true:
+	default:
+	}
+}
+
+func hasFailover() bool {
+	directLock.Lock()
+	defer directLock.Unlock()
+	return len(directCandidates) > 1
+}
+
+func getHoldDown() time.Duration {
+	holdDown := config.Config.DirectHoldDown
+	if holdDown != 0 {
+		return time.Duration(holdDown) * time.Second
+	}
+	return defaultDirectHoldDown
+}
+
+func getDirectCandidates(states []*state.State) (
+	candidates []*state.State) {
+
+	candidates = []*state.State{}
+
+	for _, stat := range states {
+		if stat.Type == state.DirectClient && len(stat.Links) != 0 {
+			candidates = append(candidates, stat)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority < candidates[j].Priority
+	})
+
+	return
+}
+
+// Policy mode routes all traffic with a 0.0.0.0/0 right subnet, only one
+// direct client can be configured at a time and failover is not supported
+func filterPolicyDirect(states []*state.State) (
+	filtered []*state.State) {
+
+	if GetDirectMode() != DirectPolicy {
+		filtered = states
+		return
+	}
+
+	candidates := getDirectCandidates(states)
+	if len(candidates) < 2 {
+		filtered = states
+		return
+	}
+	active := candidates[0]
+
+	filtered = []*state.State{}
+	for _, stat := range states {
+		if stat.Type == state.DirectClient && len(stat.Links) != 0 &&
+			stat.Id != active.Id {
+
+			logrus.WithFields(logrus.Fields{
+				"state_id":        stat.Id,
+				"active_state_id": active.Id,
+			}).Warn("ipsec: Direct failover not supported in policy " +
+				"mode, ignoring direct server")
+			continue
+		}
+		filtered = append(filtered, stat)
+	}
+
+	return
+}
+
+func setDirect(stat *state.State) (err error) {
+	err = StartTunnel(stat)
+	if err != nil {
+		return
+	}
+
+	directFailSince = time.Time{}
+	directStart = time.Now()
+
+	if stat.Type == state.DirectClient {
+		directActive = stat.Id
+		state.DirectIpsecState = stat
+	} else {
+		directActive = ""
+		state.DirectIpsecState = nil
+	}
+
+	UpdateDirect()
+
+	return
+}
+
+func deployDirect(states []*state.State) (err error) {
+	directLock.Lock()
+	defer directLock.Unlock()
+
+	for _, stat := range states {
+		if stat.Type == state.DirectServer && len(stat.Links) != 0 {
+			directCandidates = []*state.State{}
+
+			err = setDirect(stat)
+			if err != nil {
+				return
+			}
+
+			return
+		}
+	}
+
+	candidates := getDirectCandidates(states)
+	directCandidates = candidates
+
+	if len(candidates) == 0 {
+		StopTunnel()
+		directActive = ""
+		state.DirectIpsecState = nil
+		UpdateDirect()
+		return
+	}
+
+	active := candidates[0]
+	for _, candidate := range candidates {
+		if candidate.Id == directActive {
+			active = candidate
+			break
+		}
+	}
+
+	err = setDirect(active)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// Stop the direct tunnel and redeploy, required when tunnel settings
+// change without a change of tunnel endpoints
+func RebuildTunnel() {
+	directLock.Lock()
+	StopTunnel()
+	directLock.Unlock()
+
+	Redeploy()
+}
+
+func probeDirect() bool {
+	if GetDirectMode() != DirectGre {
+		return true
+	}
+
+	serverIp, err := GetDirectServerIp()
+	if err != nil {
+		return false
+	}
+
+	err = utils.ExecSilent("",
+		"ping",
+		"-c", "1",
+		"-W", "1",
+		"-I", DirectIface,
+		serverIp.String(),
+	)
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+func isConnected(stats status.Status, stat *state.State) bool {
+	stateStatus, ok := stats[stat.Id]
+	if !ok {
+		return false
+	}
+
+	return stateStatus["0"] == "connected"
+}
+
+func switchDirect(stat *state.State, reason string) {
+	logrus.WithFields(logrus.Fields{
+		"old_state_id": directActive,
+		"state_id":     stat.Id,
+		"reason":       reason,
+	}).Warn("ipsec: Switching active direct server")
+
+	err := setDirect(stat)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"state_id": stat.Id,
+			"error":    err,
+		}).Error("ipsec: Failed to switch active direct server")
+	}
+}
+
+func checkFailover() (err error) {
+	directLock.Lock()
+	candidates := directCandidates
+	activeId := directActive
+	directLock.Unlock()
+
+	if len(candidates) < 2 {
+		return
+	}
+
+	activeIndex := -1
+	for i, candidate := range candidates {
+		if candidate.Id == activeId {
+			activeIndex = i
+			break
+		}
+	}
+	if activeIndex == -1 {
+		return
+	}
+	active := candidates[activeIndex]
+
+	stats, _, err := status.Get()
+	if err != nil {
+		return
+	}
+
+	healthy := isConnected(stats, active) && probeDirect()
+
+	directLock.Lock()
+	defer directLock.Unlock()
+
+	if directActive != activeId || len(directCandidates) < 2 ||
+		directCandidates[0] != candidates[0] {
+
+		return
+	}
+
+	if healthy {
+		directFailSince = time.Time{}
+	} else if directFailSince.IsZero() {
+		directFailSince = time.Now()
+	}
+
+	if time.Since(directStart) < directStartHoldDown {
+		return
+	}
+
+	holdDown := getHoldDown()
+
+	if !directFailSince.IsZero() &&
+		time.Since(directFailSince) >= directFailTimeout {
+
+		directFailed[active.Id] = time.Now()
+
+		var next *state.State
+		var fallback *state.State
+		for i := 1; i < len(candidates); i++ {
+			candidate := candidates[(activeIndex+i)%len(candidates)]
+			if time.Since(directFailed[candidate.Id]) <= holdDown {
+				continue
+			}
+			if fallback == nil {
+				fallback = candidate
+			}
+			if isConnected(stats, candidate) {
+				next = candidate
+				break
+			}
+		}
+		if next == nil {
+			next = fallback
+		}
+		if next == nil {
+			return
+		}
+
+		switchDirect(next, "active direct server failed")
+		return
+	}
+
+	for _, candidate := range candidates[:activeIndex] {
+		if isConnected(stats, candidate) &&
+			time.Since(directFailed[candidate.Id]) > holdDown {
+
+			switchDirect(candidate, "preferred direct server recovered")
+			return
+		}
+	}
+
+	return
+}
+
+// Failover is checked on status changes, the direct tunnel is also
+// probed while there are other candidates to fail over to
+func runFailover(ctx context.Context) {
+	for {
+		var probe <-chan time.Time
+		if hasFailover() {
+			probe = time.After(directProbeRate)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-failoverNotify:
+			break
+		case <-probe:
+			break
+		}
+
+		err := checkFailover()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("ipsec: Failed to check direct failover")
+
+			if !utils.Sleep(ctx, 3*time.Second) {
+				return
+			}
+		}
+	}
+}
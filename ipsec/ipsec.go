@@ -2,6 +2,9 @@ package ipsec
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/dropbox/godropbox/errors"
@@ -10,12 +13,14 @@ import (
 	"github.com/pritunl/pritunl-link/constants"
 	"github.com/pritunl/pritunl-link/errortypes"
 	"github.com/pritunl/pritunl-link/iptables"
-	"github.com/pritunl/pritunl-link/requires"
 	"github.com/pritunl/pritunl-link/state"
+	"github.com/pritunl/pritunl-link/supervisor"
 	"github.com/pritunl/pritunl-link/utils"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -23,9 +28,13 @@ import (
 
 var (
 	updateAdvertise bool
+	updateDrain     bool
 	deployStates    []*state.State
 	curStates       []*state.State
 	deployLock      sync.Mutex
+	deployNotify    = make(chan bool, 1)
+	gracefulStart   = false
+	gracefulHash    = ""
 	updateSleepLock sync.Mutex
 	updateSleep     = constants.UpdateAdvertiseRate
 )
@@ -39,9 +48,66 @@ type templateData struct {
 	RightId      string
 	RightSubnets string
 	PreSharedKey string
+	KeyExchange  string
+	Ike          string
+	Esp          string
 }
 
-func putIpTables(stat *state.State) (err error) {
+func getTemplateData(stat *state.State, i int,
+	publicAddr string) (data *templateData) {
+
+	link := stat.Links[i]
+	leftSubnets := strings.Join(link.LeftSubnets, ",")
+	rightSubnets := strings.Join(link.RightSubnets, ",")
+
+	if GetDirectMode() == DirectPolicy {
+		if stat.Type == state.DirectServer {
+			leftSubnets = "0.0.0.0/0"
+		} else if stat.Type == state.DirectClient {
+			rightSubnets = "0.0.0.0/0"
+		}
+	}
+
+	data = &templateData{
+		Id:           fmt.Sprintf("%s-%d", stat.Id, i),
+		Left:         publicAddr,
+		LeftId:       fmt.Sprintf("%s-%s", stat.Id, publicAddr),
+		LeftSubnets:  leftSubnets,
+		Right:        link.Right,
+		RightId:      fmt.Sprintf("%s-%s", stat.Id, link.Right),
+		RightSubnets: rightSubnets,
+		PreSharedKey: link.PreSharedKey,
+		KeyExchange:  "ikev2",
+	}
+
+	if stat.Type == state.Static {
+		data.LeftId = link.LeftId
+		if data.LeftId == "" {
+			data.LeftId = publicAddr
+		}
+		data.RightId = link.RightId
+		if data.RightId == "" {
+			data.RightId = link.Right
+		}
+		data.KeyExchange = link.KeyExchange
+
+		// Proposals are pinned so the exported peer config matches
+		data.Ike = link.Ike
+		if data.Ike == "" {
+			data.Ike = defaultStaticIke
+		}
+		data.Esp = link.Esp
+		if data.Esp == "" {
+			data.Esp = defaultStaticEsp
+		}
+	}
+
+	return
+}
+
+func getIpTablesRules(stat *state.State) (
+	rules []*iptables.Rule, err error) {
+
 	clientLocal := ""
 	if len(stat.Links) > 0 && len(stat.Links[0].RightSubnets) > 0 {
 		clientLocal = stat.Links[0].RightSubnets[0]
@@ -72,115 +138,32 @@ func putIpTables(stat *state.State) (err error) {
 		return
 	}
 
-	err = iptables.UpsertRule(
-		"nat",
-		"PREROUTING",
-		"-d", localAddress,
-		"-p", "udp",
-		"-m", "udp",
-		"--dport", "500",
-		"-j", "ACCEPT",
-		"-m", "comment",
-		"--comment", "pritunl-zero",
-	)
-	if err != nil {
-		return
-	}
-	err = iptables.UpsertRule(
-		"nat",
-		"PREROUTING",
-		"-d", localAddress,
-		"-p", "udp",
-		"-m", "udp",
-		"--dport", "4500",
-		"-j", "ACCEPT",
-		"-m", "comment",
-		"--comment", "pritunl-zero",
-	)
-	if err != nil {
-		return
-	}
-	err = iptables.UpsertRule(
-		"nat",
-		"PREROUTING",
-		"-d", publicAddress,
-		"-p", "udp",
-		"-m", "udp",
-		"--dport", "500",
-		"-j", "ACCEPT",
-		"-m", "comment",
-		"--comment", "pritunl-zero",
-	)
-	if err != nil {
-		return
-	}
-	err = iptables.UpsertRule(
-		"nat",
-		"PREROUTING",
-		"-d", publicAddress,
-		"-p", "udp",
-		"-m", "udp",
-		"--dport", "4500",
-		"-j", "ACCEPT",
-		"-m", "comment",
-		"--comment", "pritunl-zero",
-	)
-	if err != nil {
-		return
+	rules = []*iptables.Rule{}
+
+	for _, addr := range []string{localAddress, publicAddress} {
+		for _, port := range []string{"500", "4500"} {
+			rules = append(rules, &iptables.Rule{
+				Table:       "nat",
+				Chain:       "PREROUTING",
+				Destination: addr,
+				Protocol:    "udp",
+				DestPort:    port,
+				Target:      "ACCEPT",
+			})
+		}
 	}
 
 	if config.Config.DirectSsh {
-		err = iptables.UpsertRule(
-			"nat",
-			"PREROUTING",
-			"-d", localAddress,
-			"-p", "tcp",
-			"-m", "tcp",
-			"--dport", "22",
-			"-j", "ACCEPT",
-			"-m", "comment",
-			"--comment", "pritunl-zero",
-		)
-		if err != nil {
-			return
+		for _, addr := range []string{localAddress, publicAddress} {
+			rules = append(rules, &iptables.Rule{
+				Table:       "nat",
+				Chain:       "PREROUTING",
+				Destination: addr,
+				Protocol:    "tcp",
+				DestPort:    "22",
+				Target:      "ACCEPT",
+			})
 		}
-		err = iptables.UpsertRule(
-			"nat",
-			"PREROUTING",
-			"-d", publicAddress,
-			"-p", "tcp",
-			"-m", "tcp",
-			"--dport", "22",
-			"-j", "ACCEPT",
-			"-m", "comment",
-			"--comment", "pritunl-zero",
-		)
-		if err != nil {
-			return
-		}
-	} else {
-		iptables.DeleteRule(
-			"nat",
-			"PREROUTING",
-			"-d", localAddress,
-			"-p", "tcp",
-			"-m", "tcp",
-			"--dport", "22",
-			"-j", "ACCEPT",
-			"-m", "comment",
-			"--comment", "pritunl-zero",
-		)
-		iptables.DeleteRule(
-			"nat",
-			"PREROUTING",
-			"-d", publicAddress,
-			"-p", "tcp",
-			"-m", "tcp",
-			"--dport", "22",
-			"-j", "ACCEPT",
-			"-m", "comment",
-			"--comment", "pritunl-zero",
-		)
 	}
 
 	directSource := directClientIp
@@ -188,61 +171,74 @@ func putIpTables(stat *state.State) (err error) {
 		directSource = clientLocal
 	}
 
-	err = iptables.UpsertRule(
-		"nat",
-		"PREROUTING",
-		"-d", localAddress,
-		"-j", "DNAT",
-		"--to-destination", directSource,
-		"-m", "comment",
-		"--comment", "pritunl-zero",
-	)
-	if err != nil {
-		return
-	}
-	err = iptables.UpsertRule(
-		"nat",
-		"PREROUTING",
-		"-d", publicAddress,
-		"-j", "DNAT",
-		"--to-destination", directSource,
-		"-m", "comment",
-		"--comment", "pritunl-zero",
-	)
-	if err != nil {
-		return
+	for _, addr := range []string{localAddress, publicAddress} {
+		rules = append(rules, &iptables.Rule{
+			Table:         "nat",
+			Chain:         "PREROUTING",
+			Destination:   addr,
+			Target:        "DNAT",
+			ToDestination: directSource,
+		})
+	}
+
+	rules = append(rules, &iptables.Rule{
+		Table:        "nat",
+		Chain:        "POSTROUTING",
+		Source:       directSource + "/32",
+		OutInterface: defaultIface,
+		Target:       "MASQUERADE",
+	})
+
+	rules = append(rules, &iptables.Rule{
+		Table:    "mangle",
+		Chain:    "FORWARD",
+		Source:   directSource + "/32",
+		Protocol: "tcp",
+		Target:   "TCPMSS",
+		SetMss:   1320,
+	})
+
+	return
+}
+
+func getDrainRules() (rules []*iptables.Rule) {
+	rules = []*iptables.Rule{}
+
+	// Only new sessions are dropped, established IKE and ESP traffic is
+	// left to continue until peers move to another host
+	for _, port := range []string{"500", "4500"} {
+		rules = append(rules, &iptables.Rule{
+			Table:    "filter",
+			Chain:    "INPUT",
+			Protocol: "udp",
+			DestPort: port,
+			State:    "NEW",
+			Target:   "DROP",
+		})
 	}
 
-	err = iptables.UpsertRule(
-		"nat",
-		"POSTROUTING",
-		"-s", directSource+"/32",
-		"-o", defaultIface,
-		"-j", "MASQUERADE",
-		"-m", "comment",
-		"--comment", "pritunl-zero",
-	)
-	if err != nil {
-		return
+	return
+}
+
+func getConfHash() string {
+	hash := sha256.New()
+
+	paths := []string{
+		constants.IpsecConfPath,
+		constants.IpsecSecretsPath,
 	}
 
-	err = iptables.UpsertRule(
-		"mangle",
-		"FORWARD",
-		"-s", directSource+"/32",
-		"-p", "tcp",
-		"-m", "tcp",
-		"--tcp-flags", "SYN,RST", "SYN",
-		"-j", "TCPMSS",
-		"--set-mss", "1320",
-		"-m", "comment",
-		"--comment", "pritunl-zero",
-	)
-	if err != nil {
-		return
+	names, _ := filepath.Glob(path.Join(constants.IpsecDirPath, "*.conf"))
+	sort.Strings(names)
+	paths = append(paths, names...)
+
+	for _, pth := range paths {
+		data, _ := ioutil.ReadFile(pth)
+		hash.Write([]byte(pth))
+		hash.Write(data)
 	}
 
-	return
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 func clearDir() (err error) {
@@ -293,36 +289,31 @@ func writeTemplates(states []*state.State) (err error) {
 
 	publicAddr := state.GetPublicAddress()
 	if publicAddr == "" {
+		err = iptables.ClearIpTables()
+		if err != nil {
+			return
+		}
+
 		return
 	}
 
 	iptablesState := false
+	iptablesRules := []*iptables.Rule{}
+
+	if config.Config.Drain {
+		iptablesState = true
+		iptablesRules = append(iptablesRules, getDrainRules()...)
+	}
 
 	for _, stat := range states {
 		confBuf := &bytes.Buffer{}
 
-		for i, link := range stat.Links {
-			leftSubnets := strings.Join(link.LeftSubnets, ",")
-			rightSubnets := strings.Join(link.RightSubnets, ",")
-
-			if GetDirectMode() == DirectPolicy {
-				if stat.Type == state.DirectServer {
-					leftSubnets = "0.0.0.0/0"
-				} else if stat.Type == state.DirectClient {
-					rightSubnets = "0.0.0.0/0"
-				}
+		for i := range stat.Links {
+			if state.IsConflict(fmt.Sprintf("%s-%d", stat.Id, i)) {
+				continue
 			}
 
-			data := &templateData{
-				Id:           fmt.Sprintf("%s-%d", stat.Id, i),
-				Left:         publicAddr,
-				LeftId:       fmt.Sprintf("%s-%s", stat.Id, publicAddr),
-				LeftSubnets:  leftSubnets,
-				Right:        link.Right,
-				RightId:      fmt.Sprintf("%s-%s", stat.Id, link.Right),
-				RightSubnets: rightSubnets,
-				PreSharedKey: link.PreSharedKey,
-			}
+			data := getTemplateData(stat, i, publicAddr)
 
 			err = confTemplate.Execute(confBuf, data)
 			if err != nil {
@@ -346,10 +337,12 @@ func writeTemplates(states []*state.State) (err error) {
 		if stat.Type == state.DirectServer && len(stat.Links) != 0 {
 			iptablesState = true
 
-			err = putIpTables(stat)
-			if err != nil {
+			rules, e := getIpTablesRules(stat)
+			if e != nil {
+				err = e
 				return
 			}
+			iptablesRules = append(iptablesRules, rules...)
 		}
 
 		pth := path.Join(constants.IpsecDirPath,
@@ -372,17 +365,22 @@ func writeTemplates(states []*state.State) (err error) {
 		return
 	}
 
-	if !iptablesState {
+	if iptablesState {
+		err = iptables.SetRules(iptablesRules)
+	} else {
 		err = iptables.ClearIpTables()
-		if err != nil {
-			return
-		}
 	}
+	if err != nil {
+		state.SetError("iptables", state.SeverityError,
+			"Firewall rules update failed", err)
+		return
+	}
+	state.ClearError("iptables")
 
 	return
 }
 
-func deploy(states []*state.State) (err error) {
+func deploy(ctx context.Context, states []*state.State) (err error) {
 	if constants.Interrupt {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "state: Interrupt"),
@@ -390,32 +388,10 @@ func deploy(states []*state.State) (err error) {
 		return
 	}
 
-	isDirect := false
-	for _, stat := range states {
-		if (stat.Type == state.DirectClient ||
-			stat.Type == state.DirectServer) && len(stat.Links) != 0 {
+	states = resolveConflicts(states)
+	states = filterPolicyDirect(states)
 
-			err = StartTunnel(stat)
-			if err != nil {
-				return
-			}
-
-			isDirect = true
-
-			if stat.Type == state.DirectClient {
-				state.DirectIpsecState = stat
-			}
-
-			break
-		}
-	}
-
-	if !isDirect {
-		StopTunnel()
-		state.DirectIpsecState = nil
-	}
-
-	err = iptables.ClearIpTables()
+	err = deployDirect(states)
 	if err != nil {
 		return
 	}
@@ -440,17 +416,39 @@ func deploy(states []*state.State) (err error) {
 		return
 	}
 
-	err = advertise.Ports(states)
+	err = advertise.Ports(ctx, states)
 	if err != nil {
 		return
 	}
 
-	err = utils.Exec("", "ipsec", "restart")
-	if err != nil {
-		return
+	confHash := getConfHash()
+	if gracefulStart {
+		gracefulStart = false
+
+		if confHash == gracefulHash && utils.ExecSilent(
+			"", "ipsec", "status") == nil {
+
+			logrus.Info("ipsec: Configuration unchanged, " +
+				"keeping existing IPsec connections")
+		} else {
+			err = utils.ExecContext(ctx, "", "ipsec", "rereadsecrets")
+			if err != nil {
+				return
+			}
+
+			err = utils.ExecContext(ctx, "", "ipsec", "update")
+			if err != nil {
+				return
+			}
+		}
+	} else {
+		err = utils.ExecContext(ctx, "", "ipsec", "restart")
+		if err != nil {
+			return
+		}
 	}
 
-	err = advertise.Routes(states)
+	err = advertise.Routes(ctx, states)
 	if err != nil {
 		return
 	}
@@ -467,7 +465,7 @@ func deploy(states []*state.State) (err error) {
 	return
 }
 
-func update(states []*state.State) (err error) {
+func update(ctx context.Context, states []*state.State) (err error) {
 	if constants.Interrupt {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "state: Interrupt"),
@@ -490,12 +488,14 @@ func update(states []*state.State) (err error) {
 		return
 	}
 
-	err = advertise.Ports(states)
+	states = resolveConflicts(states)
+
+	err = advertise.Ports(ctx, states)
 	if err != nil {
 		return
 	}
 
-	err = advertise.Routes(states)
+	err = advertise.Routes(ctx, states)
 	if err != nil {
 		return
 	}
@@ -503,10 +503,18 @@ func update(states []*state.State) (err error) {
 	return
 }
 
+func notifyDeploy() {
+	select {
+	case deployNotify <- true:
+	default:
+	}
+}
+
 func Deploy(states []*state.State) {
 	deployLock.Lock()
 	deployStates = states
 	deployLock.Unlock()
+	notifyDeploy()
 }
 
 func Redeploy() {
@@ -515,27 +523,90 @@ func Redeploy() {
 		deployStates = curStates
 	}
 	deployLock.Unlock()
+	notifyDeploy()
 }
 
-func runDeploy() {
+func UpdateAdvertise() {
+	deployLock.Lock()
+	updateAdvertise = true
+	deployLock.Unlock()
+	notifyDeploy()
+}
+
+func UpdateDrain() {
+	deployLock.Lock()
+	updateDrain = true
+	deployLock.Unlock()
+	notifyDeploy()
+}
+
+// Apply drain state without restarting IPsec so established links are
+// kept and undrain only needs to restore the rules and routes
+func drain(ctx context.Context, states []*state.State) (err error) {
+	if config.Config.Drain {
+		logrus.Info("ipsec: Draining host, withdrawing routes " +
+			"and blocking new IKE sessions")
+	} else {
+		logrus.Info("ipsec: Undraining host, restoring routes " +
+			"and accepting new IKE sessions")
+	}
+
+	states = resolveConflicts(states)
+
+	err = writeTemplates(states)
+	if err != nil {
+		return
+	}
+
+	err = advertise.Routes(ctx, states)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func runDeploy(ctx context.Context) {
 	for {
-		if deployStates != nil || updateAdvertise {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deployNotify:
+		}
+
+		deployLock.Lock()
+		pending := deployStates != nil || updateAdvertise || updateDrain
+		deployLock.Unlock()
+
+		if pending {
 			deployLock.Lock()
 			states := deployStates
 			updateAd := false
+			updateDr := false
 			deployStates = nil
 			if states != nil {
 				curStates = states
+			} else if updateDrain {
+				updateDr = true
+				states = curStates
 			} else if updateAdvertise {
 				updateAd = true
 				states = curStates
 			}
+			updateDrain = false
 			updateAdvertise = false
 			deployLock.Unlock()
 
 			if states != nil {
-				if updateAd {
-					update(states)
+				if updateDr {
+					err := drain(ctx, states)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{
+							"error": err,
+						}).Info("state: Failed to update drain state")
+					}
+				} else if updateAd {
+					update(ctx, states)
 				} else {
 					logrus.WithFields(logrus.Fields{
 						"default_interface": state.GetDefaultInterface(),
@@ -544,20 +615,28 @@ func runDeploy() {
 						"address6":          state.GetAddress6(),
 					}).Info("state: Deploying state")
 
-					err := deploy(states)
+					err := deploy(ctx, states)
 					if err != nil {
 						logrus.WithFields(logrus.Fields{
 							"error": err,
 						}).Info("state: Failed to deploy state")
 
-						time.Sleep(3 * time.Second)
+						state.SetError("deploy", state.SeverityError,
+							"IPsec deploy failed", err)
+
+						if !utils.Sleep(ctx, 3*time.Second) {
+							return
+						}
 
 						deployLock.Lock()
 						if deployStates == nil {
 							deployStates = states
 						}
 						deployLock.Unlock()
+						notifyDeploy()
 					} else {
+						state.ClearError("deploy")
+
 						updateSleepLock.Lock()
 						updateSleep = constants.UpdateAdvertiseReplay
 						updateSleepLock.Unlock()
@@ -565,15 +644,15 @@ func runDeploy() {
 				}
 			}
 		}
-
-		time.Sleep(10 * time.Millisecond)
 	}
 }
 
-func runUpdateAdvertise() {
+func runUpdateAdvertise(ctx context.Context) {
 	for {
 		for {
-			time.Sleep(1 * time.Second)
+			if !utils.Sleep(ctx, 1*time.Second) {
+				return
+			}
 
 			updateSleepLock.Lock()
 			updateSleep -= 1
@@ -588,18 +667,23 @@ func runUpdateAdvertise() {
 
 		states := curStates
 		if states != nil {
-			update(states)
+			update(ctx, states)
 		}
 	}
 }
 
-func init() {
-	module := requires.New("ipsec")
-	module.After("logger")
-
-	module.Handler = func() {
-		go runDeploy()
-		go runUpdateAdvertise()
-		go runRoutes()
+// Start background tasks, with graceful set the dataplane left by a
+// previous process is reconciled instead of rebuilt
+func Start(sup *supervisor.Supervisor, graceful bool) {
+	if graceful {
+		gracefulStart = true
+		gracefulHash = getConfHash()
+	} else {
+		DelDirectRoute()
 	}
+
+	sup.Go("ipsec.deploy", runDeploy)
+	sup.Go("ipsec.update_advertise", runUpdateAdvertise)
+	sup.Go("ipsec.routes", runRoutes)
+	sup.Go("ipsec.failover", runFailover)
 }
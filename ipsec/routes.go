@@ -1,11 +1,17 @@
 package ipsec
 
 import (
+	"context"
+	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/pritunl/pritunl-link/constants"
+	"github.com/pritunl/pritunl-link/network"
 	"github.com/pritunl/pritunl-link/state"
 	"github.com/pritunl/pritunl-link/status"
 	"github.com/pritunl/pritunl-link/utils"
+	"net"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -13,6 +19,7 @@ var (
 	routesPeer         = ""
 	routesGateway      = ""
 	routesDefaultIface = ""
+	routesExempt       = ""
 )
 
 func getDirectStatus(stat *state.State) (directStatus bool, err error) {
@@ -40,86 +47,204 @@ func getDirectStatus(stat *state.State) (directStatus bool, err error) {
 	return
 }
 
-func addDirectRoute(peer, gateway, defaultIface string) (err error) {
+func ruleKey(rule *network.Rule) string {
+	dst := rule.Destination
+	if dst != "" {
+		if !strings.Contains(dst, "/") {
+			dst += "/32"
+		}
+
+		_, dstNet, err := net.ParseCIDR(dst)
+		if err == nil {
+			dst = dstNet.String()
+		}
+	}
+
+	return fmt.Sprintf("%d-%d-%s-%d-%t", rule.Priority, rule.Table, dst,
+		rule.Mark, rule.SuppressDefault)
+}
+
+// Check if the direct rules are already installed, such as after a
+// graceful restart
+func hasDirectRules(rules []*network.Rule) bool {
+	curRules, err := network.RuleList(directPriorityExempt, directPriorityMax)
+	if err != nil || len(curRules) != len(rules) {
+		return false
+	}
+
+	keys := map[string]bool{}
+	for _, rule := range curRules {
+		keys[ruleKey(rule)] = true
+	}
+
+	for _, rule := range rules {
+		if !keys[ruleKey(rule)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getDirectRules(peer string, exempt []string) (rules []*network.Rule) {
+	rules = []*network.Rule{
+		{
+			Priority:    directPriorityExempt,
+			Table:       syscall.RT_TABLE_MAIN,
+			Destination: peer,
+		},
+	}
+
+	for _, exemptNet := range exempt {
+		rules = append(rules, &network.Rule{
+			Priority:    directPriorityExempt,
+			Table:       syscall.RT_TABLE_MAIN,
+			Destination: exemptNet,
+		})
+	}
+
+	rules = append(rules, &network.Rule{
+		Priority:        directPrioritySuppress,
+		Table:           syscall.RT_TABLE_MAIN,
+		SuppressDefault: true,
+	}, &network.Rule{
+		Priority: directPriorityTable,
+		Table:    DirectTable,
+	})
+
+	return
+}
+
+func addDirectRoute(peer string, exempt []string) (err error) {
+	rules := getDirectRules(peer, exempt)
+
+	if hasDirectRules(rules) {
+		err = network.TableRouteAdd(
+			DirectTable, "0.0.0.0/0", "", DirectIface)
+		if err != nil && !network.IsExists(err) {
+			DelDirectRoute()
+			return
+		}
+		err = nil
+
+		return
+	}
+
 	DelDirectRoute()
 
 	if constants.Interrupt {
 		return
 	}
 
-	utils.ExecSilent("",
-		"ip", "route",
-		"del", peer,
-		"via", gateway,
-		"dev", defaultIface,
-	)
-	err = utils.Exec("",
-		"ip", "route",
-		"add", peer,
-		"via", gateway,
-		"dev", defaultIface,
-	)
+	err = network.TableRouteAdd(DirectTable, "0.0.0.0/0", "", DirectIface)
 	if err != nil {
-		utils.ExecSilent("",
-			"ip", "route",
-			"del", peer,
-			"via", gateway,
-			"dev", defaultIface,
-		)
+		DelDirectRoute()
 		return
 	}
 
-	err = utils.Exec("",
-		"ip", "route",
-		"add", "0.0.0.0/0",
-		"dev", DirectIface,
-	)
-	if err != nil {
-		utils.ExecSilent("",
-			"ip", "route",
-			"del", peer,
-			"via", gateway,
-			"dev", defaultIface,
-		)
-		utils.ExecSilent("",
-			"ip", "route",
-			"del", "0.0.0.0/0",
-			"dev", DirectIface,
-		)
-		return
+	for _, rule := range rules {
+		err = network.RuleAdd(rule)
+		if err != nil {
+			DelDirectRoute()
+			return
+		}
+	}
+
+	return
+}
+
+// Add and remove only the exempt rules that changed
+func updateExemptRules(curExempt, newExempt []string) (err error) {
+	curSet := map[string]bool{}
+	for _, exemptNet := range curExempt {
+		curSet[exemptNet] = true
+	}
+
+	newSet := map[string]bool{}
+	for _, exemptNet := range newExempt {
+		newSet[exemptNet] = true
+	}
+
+	for _, exemptNet := range curExempt {
+		if newSet[exemptNet] {
+			continue
+		}
+
+		err = network.RuleDel(&network.Rule{
+			Priority:    directPriorityExempt,
+			Table:       syscall.RT_TABLE_MAIN,
+			Destination: exemptNet,
+		})
+		if err != nil && !network.IsNotFound(err) {
+			return
+		}
+		err = nil
+	}
+
+	for _, exemptNet := range newExempt {
+		if curSet[exemptNet] {
+			continue
+		}
+
+		err = network.RuleAdd(&network.Rule{
+			Priority:    directPriorityExempt,
+			Table:       syscall.RT_TABLE_MAIN,
+			Destination: exemptNet,
+		})
+		if err != nil && !network.IsExists(err) {
+			return
+		}
+		err = nil
 	}
 
 	return
 }
 
 func DelDirectRoute() {
-	if routesPeer != "" && routesGateway != "" && routesDefaultIface != "" {
-		utils.ExecSilent("",
-			"ip", "route",
-			"del", routesPeer,
-			"via", routesGateway,
-			"dev", routesDefaultIface,
-		)
+	err := network.RuleClear(directPriorityExempt, directPriorityMax)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("ipsec: Failed to clear direct routing rules")
 	}
 
-	utils.ExecSilent("",
-		"ip", "route",
-		"del", "0.0.0.0/0",
-		"dev", DirectIface,
-	)
+	err = network.TableFlush(DirectTable)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("ipsec: Failed to flush direct routing table")
+	}
+
+	network.RouteDel("0.0.0.0/0", "", DirectIface)
 
 	routesPeer = ""
 	routesGateway = ""
 	routesDefaultIface = ""
+	routesExempt = ""
 }
 
-func runRoutes() {
+func runRoutes(ctx context.Context) {
+	retry := false
+
 	for {
-		time.Sleep(300 * time.Millisecond)
-		if constants.Interrupt {
-			return
+		// Exempt hosts are resolved again while routes are active
+		var resolve <-chan time.Time
+		if routesPeer != "" {
+			resolve = time.After(exemptResolveTtl)
 		}
 
+		if !retry {
+			select {
+			case <-ctx.Done():
+				return
+			case <-routesNotify:
+				break
+			case <-resolve:
+				break
+			}
+		}
+		retry = false
+
 		newRoutesPeer := ""
 		directStatus := false
 		ipsecState := state.DirectIpsecState
@@ -132,8 +257,11 @@ func runRoutes() {
 					"error": err,
 				}).Error("sync: Failed to get status")
 
-				time.Sleep(3 * time.Second)
+				if !utils.Sleep(ctx, 3*time.Second) {
+					return
+				}
 
+				retry = true
 				continue
 			}
 
@@ -143,34 +271,72 @@ func runRoutes() {
 		newRoutesDefaultIface := state.GetDefaultInterface()
 
 		if newRoutesPeer != "" && directStatus {
-			if routesPeer != newRoutesPeer ||
+			exempt := GetDirectExempt()
+			newRoutesExempt := strings.Join(exempt, ",")
+
+			if routesPeer == newRoutesPeer &&
+				routesGateway == newRoutesGateway &&
+				routesDefaultIface == newRoutesDefaultIface &&
+				routesExempt != newRoutesExempt {
+
+				logrus.WithFields(logrus.Fields{
+					"peer":   newRoutesPeer,
+					"exempt": exempt,
+				}).Info("ipsec: Updating IPsec route exemptions")
+
+				curExempt := []string{}
+				if routesExempt != "" {
+					curExempt = strings.Split(routesExempt, ",")
+				}
+
+				err := updateExemptRules(curExempt, exempt)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error": err,
+					}).Error("ipsec: Failed to update IPsec route exemptions")
+
+					// Force a full rebuild of the rules on the next pass
+					routesPeer = ""
+
+					if !utils.Sleep(ctx, 3*time.Second) {
+						return
+					}
+
+					retry = true
+					continue
+				}
+
+				routesExempt = newRoutesExempt
+			} else if routesPeer != newRoutesPeer ||
 				routesGateway != newRoutesGateway ||
-				routesDefaultIface != newRoutesDefaultIface {
+				routesDefaultIface != newRoutesDefaultIface ||
+				routesExempt != newRoutesExempt {
 
 				logrus.WithFields(logrus.Fields{
 					"peer":          newRoutesPeer,
 					"gateway":       newRoutesGateway,
 					"default_iface": newRoutesDefaultIface,
+					"exempt":        exempt,
 				}).Info("ipsec: Adding IPsec routes")
 
-				err := addDirectRoute(
-					newRoutesPeer,
-					newRoutesGateway,
-					newRoutesDefaultIface,
-				)
+				err := addDirectRoute(newRoutesPeer, exempt)
 				if err != nil {
 					logrus.WithFields(logrus.Fields{
 						"error": err,
 					}).Error("ipsec: Failed to add IPsec routes")
 
-					time.Sleep(3 * time.Second)
+					if !utils.Sleep(ctx, 3*time.Second) {
+						return
+					}
 
+					retry = true
 					continue
 				}
 
 				routesPeer = newRoutesPeer
 				routesGateway = newRoutesGateway
 				routesDefaultIface = newRoutesDefaultIface
+				routesExempt = newRoutesExempt
 			}
 		} else if routesPeer != "" {
 			logrus.WithFields(logrus.Fields{
@@ -4,8 +4,8 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/network"
 	"github.com/pritunl/pritunl-link/state"
-	"github.com/pritunl/pritunl-link/utils"
 	"net"
 	"strings"
 )
@@ -40,6 +40,14 @@ func StartTunnel(stat *state.State) (err error) {
 	if newTunnelLocal == tunnelLocal && newTunnelRemote == tunnelRemote {
 		return
 	}
+
+	if tunnelLocal == "" && tunnelRemote == "" {
+		adopted := adoptTunnel(stat, newTunnelLocal, newTunnelRemote)
+		if adopted {
+			return
+		}
+	}
+
 	StopTunnel()
 
 	if newTunnelLocal == "" || newTunnelRemote == "" {
@@ -54,21 +62,12 @@ func StartTunnel(stat *state.State) (err error) {
 		"remote": newTunnelRemote,
 	}).Info("ipsec: Starting GRE tunnel")
 
-	err = utils.Exec("",
-		"ip", "tunnel",
-		"add", DirectIface,
-		"mode", "gre",
-		"local", newTunnelLocal,
-		"remote", newTunnelRemote,
-	)
+	err = network.GreAdd(DirectIface, newTunnelLocal, newTunnelRemote)
 	if err != nil {
 		return
 	}
 
-	err = utils.Exec("",
-		"ip", "link",
-		"set", DirectIface, "up",
-	)
+	err = network.LinkUp(DirectIface)
 	if err != nil {
 		return
 	}
@@ -84,11 +83,7 @@ func StartTunnel(stat *state.State) (err error) {
 	}
 	directAddr := directAddrIp.String()
 
-	err = utils.Exec("",
-		"ip", "addr",
-		"add", directAddr+"/"+GetDirectCidr(),
-		"dev", DirectIface,
-	)
+	err = network.AddrAdd(DirectIface, directAddr+"/"+GetDirectCidr())
 	if err != nil {
 		return
 	}
@@ -96,6 +91,45 @@ func StartTunnel(stat *state.State) (err error) {
 	return
 }
 
+// Reuse a matching tunnel left by a previous process
+func adoptTunnel(stat *state.State, local, remote string) bool {
+	curLocal, curRemote, err := network.GreGet(DirectIface)
+	if err != nil || curLocal != local || curRemote != remote {
+		return false
+	}
+
+	err = network.LinkUp(DirectIface)
+	if err != nil {
+		return false
+	}
+
+	var directAddrIp net.IP
+	if stat.Type == state.DirectClient {
+		directAddrIp, err = GetDirectClientIp()
+	} else {
+		directAddrIp, err = GetDirectServerIp()
+	}
+	if err != nil {
+		return false
+	}
+
+	err = network.AddrAdd(DirectIface,
+		directAddrIp.String()+"/"+GetDirectCidr())
+	if err != nil && !network.IsExists(err) {
+		return false
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"local":  local,
+		"remote": remote,
+	}).Info("ipsec: Reusing existing GRE tunnel")
+
+	tunnelLocal = local
+	tunnelRemote = remote
+
+	return true
+}
+
 func StopTunnel() {
 	if tunnelLocal != "" && tunnelRemote != "" {
 		logrus.WithFields(logrus.Fields{
@@ -104,10 +138,7 @@ func StopTunnel() {
 		}).Info("ipsec: Stopping GRE tunnel")
 	}
 
-	utils.ExecSilent("",
-		"ip", "tunnel",
-		"del", DirectIface,
-	)
+	network.LinkDel(DirectIface)
 	tunnelLocal = ""
 	tunnelRemote = ""
 }
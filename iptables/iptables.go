@@ -1,11 +1,83 @@
 package iptables
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/utils"
+	"os/exec"
 	"strings"
 )
 
-func ClearIpTables() (err error) {
+const (
+	Auto     = "auto"
+	Iptables = "iptables"
+	Nftables = "nftables"
+
+	chainPrefix = "PRITUNL-"
+)
+
+var (
+	detectedBackend = ""
+	appliedBackend  = ""
+	chains          = []struct {
+		Table  string
+		Chain  string
+		Insert bool
+	}{
+		{"nat", "PREROUTING", false},
+		{"nat", "POSTROUTING", false},
+		{"mangle", "FORWARD", false},
+		{"filter", "INPUT", true},
+	}
+)
+
+func detectBackend() string {
+	if detectedBackend != "" {
+		return detectedBackend
+	}
+
+	backend := Iptables
+
+	_, err := exec.LookPath("nft")
+	if err == nil {
+		_, err = exec.LookPath("iptables")
+		if err != nil {
+			backend = Nftables
+		} else {
+			output, _ := utils.ExecCombinedOutput("", "iptables", "-V")
+			if strings.Contains(output, "nf_tables") {
+				backend = Nftables
+			}
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"backend": backend,
+	}).Info("iptables: Detected firewall backend")
+
+	detectedBackend = backend
+
+	return backend
+}
+
+func GetBackend() string {
+	switch config.Config.Firewall {
+	case Iptables:
+		return Iptables
+	case Nftables:
+		return Nftables
+	default:
+		return detectBackend()
+	}
+}
+
+func chainName(chain string) string {
+	return chainPrefix + chain
+}
+
+func clearRules(keepJumps bool) (err error) {
 	output, err := utils.ExecOutput("", "iptables-save")
 	if err != nil {
 		return
@@ -25,7 +97,13 @@ func ClearIpTables() (err error) {
 			continue
 		}
 
-		if !strings.Contains(line, "--comment pritunl-zero") {
+		if !strings.Contains(line, "--comment pritunl-zero") ||
+			strings.HasPrefix(line, "-A "+chainPrefix) {
+
+			continue
+		}
+
+		if keepJumps && strings.Contains(line, "-j "+chainPrefix) {
 			continue
 		}
 
@@ -41,6 +119,40 @@ func ClearIpTables() (err error) {
 	return
 }
 
+func ClearIpTables() (err error) {
+	nftClear()
+
+	err = iptablesClear()
+	if err != nil {
+		return
+	}
+
+	appliedBackend = ""
+
+	return
+}
+
+func iptablesClear() (err error) {
+	_, e := exec.LookPath("iptables-save")
+	if e != nil {
+		return
+	}
+
+	err = clearRules(false)
+	if err != nil {
+		return
+	}
+
+	for _, chain := range chains {
+		utils.ExecSilent("", "iptables", "-t", chain.Table,
+			"-F", chainName(chain.Chain))
+		utils.ExecSilent("", "iptables", "-t", chain.Table,
+			"-X", chainName(chain.Chain))
+	}
+
+	return
+}
+
 func UpsertRule(table string, rule ...string) (err error) {
 	args := []string{"-t", table, "-C"}
 	args = append(args, rule...)
@@ -59,8 +171,120 @@ func UpsertRule(table string, rule ...string) (err error) {
 	return
 }
 
+// Insert rule at the top of the chain if it does not exist, used for
+// drop rules that must not be bypassed by existing accept rules
+func InsertRule(table, chain string, rule ...string) (err error) {
+	args := []string{"-t", table, "-C", chain}
+	args = append(args, rule...)
+
+	e := utils.ExecSilent("", "iptables", args...)
+	if e != nil {
+		args = []string{"-t", table, "-I", chain, "1"}
+		args = append(args, rule...)
+
+		err = utils.Exec("", "iptables", args...)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
 func DeleteRule(table string, rule ...string) {
 	args := []string{"-t", table, "-D"}
 	args = append(args, rule...)
 	utils.ExecSilent("", "iptables", args...)
 }
+
+// Remove rules left by the other backend after the backend changes
+func clearOtherBackend(backend string) (err error) {
+	if backend == appliedBackend {
+		return
+	}
+
+	if backend == Nftables {
+		err = iptablesClear()
+		if err != nil {
+			return
+		}
+	} else {
+		nftClear()
+	}
+
+	appliedBackend = backend
+
+	return
+}
+
+func SetRules(rules []*Rule) (err error) {
+	backend := GetBackend()
+
+	err = clearOtherBackend(backend)
+	if err != nil {
+		return
+	}
+
+	if backend == Nftables {
+		err = nftSetRules(rules)
+		if err != nil {
+			return
+		}
+
+		return
+	}
+
+	buf := &bytes.Buffer{}
+
+	for _, table := range []string{"nat", "mangle", "filter"} {
+		fmt.Fprintf(buf, "*%s\n", table)
+
+		for _, chain := range chains {
+			if chain.Table != table {
+				continue
+			}
+			fmt.Fprintf(buf, ":%s - [0:0]\n", chainName(chain.Chain))
+			fmt.Fprintf(buf, "-F %s\n", chainName(chain.Chain))
+		}
+
+		for _, rule := range rules {
+			if rule.Table != table {
+				continue
+			}
+			fmt.Fprintf(buf, "-A %s %s\n", chainName(rule.Chain),
+				strings.Join(rule.iptablesArgs(), " "))
+		}
+
+		fmt.Fprintf(buf, "COMMIT\n")
+	}
+
+	err = utils.ExecInput("", buf.String(), "iptables-restore", "--noflush")
+	if err != nil {
+		return
+	}
+
+	for _, chain := range chains {
+		jump := []string{
+			"-j", chainName(chain.Chain),
+			"-m", "comment",
+			"--comment", "pritunl-zero",
+		}
+
+		if chain.Insert {
+			err = InsertRule(chain.Table, chain.Chain, jump...)
+		} else {
+			err = UpsertRule(chain.Table, append(
+				[]string{chain.Chain}, jump...)...)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	err = clearRules(true)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,56 @@
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pritunl/pritunl-link/utils"
+)
+
+const nftTable = "pritunl"
+
+var nftChains = []struct {
+	Name     string
+	Type     string
+	Priority string
+}{
+	{"prerouting", "nat", "dstnat"},
+	{"postrouting", "nat", "srcnat"},
+	{"forward", "filter", "mangle"},
+	{"input", "filter", "filter"},
+}
+
+func nftClear() {
+	utils.ExecSilent("", "nft", "delete", "table", "inet", nftTable)
+}
+
+func nftSetRules(rules []*Rule) (err error) {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "table inet %s {}\n", nftTable)
+	fmt.Fprintf(buf, "delete table inet %s\n", nftTable)
+	fmt.Fprintf(buf, "table inet %s {\n", nftTable)
+
+	for _, chain := range nftChains {
+		fmt.Fprintf(buf, "\tchain %s {\n", chain.Name)
+		fmt.Fprintf(buf, "\t\ttype %s hook %s priority %s; policy accept;\n",
+			chain.Type, chain.Name, chain.Priority)
+
+		for _, rule := range rules {
+			if rule.nftChain() != chain.Name {
+				continue
+			}
+			fmt.Fprintf(buf, "\t\t%s\n", rule.nftStatement())
+		}
+
+		fmt.Fprintf(buf, "\t}\n")
+	}
+
+	fmt.Fprintf(buf, "}\n")
+
+	err = utils.ExecInput("", buf.String(), "nft", "-f", "-")
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,109 @@
+package iptables
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Rule struct {
+	Table         string
+	Chain         string
+	Source        string
+	Destination   string
+	OutInterface  string
+	Protocol      string
+	DestPort      string
+	State         string
+	Target        string
+	ToDestination string
+	SetMss        int
+}
+
+func (r *Rule) iptablesArgs() (args []string) {
+	args = []string{}
+
+	if r.Source != "" {
+		args = append(args, "-s", r.Source)
+	}
+	if r.Destination != "" {
+		args = append(args, "-d", r.Destination)
+	}
+	if r.OutInterface != "" {
+		args = append(args, "-o", r.OutInterface)
+	}
+	if r.Protocol != "" {
+		args = append(args, "-p", r.Protocol, "-m", r.Protocol)
+	}
+	if r.DestPort != "" {
+		args = append(args, "--dport", r.DestPort)
+	}
+	if r.State != "" {
+		args = append(args, "-m", "conntrack", "--ctstate", r.State)
+	}
+	if r.Target == "TCPMSS" {
+		args = append(args, "--tcp-flags", "SYN,RST", "SYN")
+	}
+
+	args = append(args, "-j", r.Target)
+
+	switch r.Target {
+	case "DNAT":
+		args = append(args, "--to-destination", r.ToDestination)
+		break
+	case "TCPMSS":
+		args = append(args, "--set-mss", fmt.Sprintf("%d", r.SetMss))
+		break
+	}
+
+	args = append(args, "-m", "comment", "--comment", "pritunl-zero")
+
+	return
+}
+
+func (r *Rule) nftChain() string {
+	return strings.ToLower(r.Chain)
+}
+
+func (r *Rule) nftStatement() string {
+	exprs := []string{}
+
+	if r.Source != "" {
+		exprs = append(exprs, "ip saddr "+r.Source)
+	}
+	if r.Destination != "" {
+		exprs = append(exprs, "ip daddr "+r.Destination)
+	}
+	if r.OutInterface != "" {
+		exprs = append(exprs, fmt.Sprintf("oifname \"%s\"", r.OutInterface))
+	}
+	if r.Protocol != "" && r.DestPort != "" {
+		exprs = append(exprs, fmt.Sprintf("%s dport %s",
+			r.Protocol, r.DestPort))
+	} else if r.Protocol != "" {
+		exprs = append(exprs, "meta l4proto "+r.Protocol)
+	}
+	if r.State != "" {
+		exprs = append(exprs, "ct state "+strings.ToLower(r.State))
+	}
+
+	switch r.Target {
+	case "ACCEPT":
+		exprs = append(exprs, "accept")
+		break
+	case "DROP":
+		exprs = append(exprs, "drop")
+		break
+	case "DNAT":
+		exprs = append(exprs, "dnat ip to "+r.ToDestination)
+		break
+	case "MASQUERADE":
+		exprs = append(exprs, "masquerade")
+		break
+	case "TCPMSS":
+		exprs = append(exprs, "tcp flags & (syn|rst) == syn",
+			fmt.Sprintf("tcp option maxseg size set %d", r.SetMss))
+		break
+	}
+
+	return strings.Join(exprs, " ")
+}
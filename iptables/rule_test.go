@@ -0,0 +1,96 @@
+package iptables
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     *Rule
+		iptables string
+		nft      string
+	}{
+		{
+			name: "accept",
+			rule: &Rule{
+				Chain:    "FORWARD",
+				Source:   "10.0.0.0/24",
+				Protocol: "udp",
+				DestPort: "500",
+				Target:   "ACCEPT",
+			},
+			iptables: "-s 10.0.0.0/24 -p udp -m udp --dport 500 " +
+				"-j ACCEPT -m comment --comment pritunl-zero",
+			nft: "ip saddr 10.0.0.0/24 udp dport 500 accept",
+		},
+		{
+			name: "drop_new",
+			rule: &Rule{
+				Chain:    "INPUT",
+				Protocol: "udp",
+				DestPort: "4500",
+				State:    "NEW",
+				Target:   "DROP",
+			},
+			iptables: "-p udp -m udp --dport 4500 -m conntrack " +
+				"--ctstate NEW -j DROP -m comment --comment pritunl-zero",
+			nft: "udp dport 4500 ct state new drop",
+		},
+		{
+			name: "dnat",
+			rule: &Rule{
+				Chain:         "PREROUTING",
+				Destination:   "1.2.3.4",
+				Protocol:      "tcp",
+				Target:        "DNAT",
+				ToDestination: "10.0.0.2",
+			},
+			iptables: "-d 1.2.3.4 -p tcp -m tcp -j DNAT " +
+				"--to-destination 10.0.0.2 -m comment --comment pritunl-zero",
+			nft: "ip daddr 1.2.3.4 meta l4proto tcp dnat ip to 10.0.0.2",
+		},
+		{
+			name: "masquerade",
+			rule: &Rule{
+				Chain:        "POSTROUTING",
+				OutInterface: "eth0",
+				Target:       "MASQUERADE",
+			},
+			iptables: "-o eth0 -j MASQUERADE -m comment --comment pritunl-zero",
+			nft:      "oifname \"eth0\" masquerade",
+		},
+		{
+			name: "tcpmss",
+			rule: &Rule{
+				Chain:    "FORWARD",
+				Protocol: "tcp",
+				Target:   "TCPMSS",
+				SetMss:   1350,
+			},
+			iptables: "-p tcp -m tcp --tcp-flags SYN,RST SYN -j TCPMSS " +
+				"--set-mss 1350 -m comment --comment pritunl-zero",
+			nft: "meta l4proto tcp tcp flags & (syn|rst) == syn " +
+				"tcp option maxseg size set 1350",
+		},
+	}
+
+	for _, test := range tests {
+		args := strings.Join(test.rule.iptablesArgs(), " ")
+		if args != test.iptables {
+			t.Errorf("%s: iptables args %q, expected %q",
+				test.name, args, test.iptables)
+		}
+
+		stmt := test.rule.nftStatement()
+		if stmt != test.nft {
+			t.Errorf("%s: nft statement %q, expected %q",
+				test.name, stmt, test.nft)
+		}
+
+		if test.rule.nftChain() != strings.ToLower(test.rule.Chain) {
+			t.Errorf("%s: nft chain %q", test.name, test.rule.nftChain())
+		}
+	}
+}
@@ -15,23 +15,60 @@ Usage: pritunl-link COMMAND
 Commands:
   version                   Show version
   start                     Start link service
+  restart                   Restart link service keeping links up, use for upgrades
+  cleanup                   Remove links left by a graceful restart
+  drain                     Move links to peers and withdraw routes for maintenance
+  undrain                   Resume accepting links and advertising routes
   add                       Add a Pritunl server URI
   remove                    Remove a Pritunl server URI
   clear                     Clear all configured Pritunl server URIs
   list                      List Pritunl server URIs
+  enable                    Enable a Pritunl server URI by URI or label
+  disable                   Disable a Pritunl server URI without removing it
+  label                     Set label for a Pritunl server URI
+  tag                       Set tag for a Pritunl server URI
+  timeout                   Set request timeout in seconds for a Pritunl server URI
   default-interface         Manually set default interface
   default-gateway           Manually set default gateaway
   local-address             Manually set local IP address
+  local-interface           Pin local address selection to interface
+  local-exclude-add         Add interface pattern skipped for local address
+  local-exclude-remove      Remove interface pattern skipped for local address
+  default-local-exclude-on  Enable default interface patterns skipped for local address
+  default-local-exclude-off Disable default interface patterns skipped for local address
   public-address            Manually set public IP address
+  discover-add              Add public address source (http, stun, provider, static)
+  discover-remove           Remove public address source
+  discover-consensus        Set number of public address sources that must agree
   direct-ssh-on             Enable direct SSH
   direct-ssh-off            Disable direct SSH
+  direct-exempt-add         Add network routed outside of direct client tunnel
+  direct-exempt-remove      Remove network routed outside of direct client tunnel
+  default-exempt-on         Enable default direct client exemptions
+  default-exempt-off        Disable default direct client exemptions
   verify-on                 Enable HTTPS certificate verification when connecting to Pritunl server
   verify-off                Disable HTTPS certificate verification when connecting to Pritunl server
+  tls-pin                   Set SHA-256 public key pin for a Pritunl server URI
+  tls-ca                    Set CA bundle path for a Pritunl server URI
+  tls-cert                  Set client certificate and key paths for a Pritunl server URI
+  tls-clear                 Clear TLS settings for a Pritunl server URI
+  proxy                     Set HTTP proxy for outbound requests, direct to ignore environment
+  uri-proxy                 Set HTTP proxy for a Pritunl server URI, direct to bypass proxy
+  no-proxy-add              Add host, domain or network that bypasses the proxy
+  no-proxy-remove           Remove host, domain or network that bypasses the proxy
+  legacy-cipher-on          Allow legacy AES-CBC responses from Pritunl server
+  legacy-cipher-off         Require authenticated encryption for Pritunl server responses
   disconnected-timeout-on   Enable restart when disconnected for duration of timeout
   disconnected-timeout-off  Disable restart when disconnected for duration of timeout
   advertise-update-on       Enable recurring checks and updates of routing table and port forwarding
   advertise-update-off      Disable recurring checks and updates of routing table and port forwarding
   provider                  Manually set network provider
+  firewall                  Set firewall backend (auto, iptables, nftables)
+  subnet-conflict           Set subnet conflict policy (detect, reject, priority, specific)
+  static-add                Add static link (name, right, psk path, left subnets, right subnets)
+  static-remove             Remove static link
+  static-option             Set static link option (left-id, right-id, keyexchange, ike, esp, enabled)
+  export-peer               Export remote peer config for state link (strongswan, libreswan, vyos, mikrotik, cisco)
   oracle-region             Set Oracle region
   oracle-private-key        Set Oracle base64 private key
   oracle-user-ocid          Set Oracle user ocid
@@ -66,6 +103,34 @@ func main() {
 			panic(err)
 		}
 		break
+	case "restart":
+		Init()
+		err := cmd.Restart()
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "cleanup":
+		Init()
+		err := cmd.CleanUp()
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "drain":
+		Init()
+		err := cmd.Drain()
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "undrain":
+		Init()
+		err := cmd.Undrain()
+		if err != nil {
+			panic(err)
+		}
+		break
 	case "add":
 		Init()
 		err := cmd.Add(flag.Arg(1))
@@ -94,6 +159,41 @@ func main() {
 			panic(err)
 		}
 		break
+	case "enable":
+		Init()
+		err := cmd.Enable(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "disable":
+		Init()
+		err := cmd.Disable(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "label":
+		Init()
+		err := cmd.Label(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "tag":
+		Init()
+		err := cmd.Tag(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "timeout":
+		Init()
+		err := cmd.Timeout(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			panic(err)
+		}
+		break
 	case "default-interface":
 		Init()
 		err := cmd.DefaultInterface(flag.Arg(1))
@@ -115,6 +215,41 @@ func main() {
 			panic(err)
 		}
 		break
+	case "local-interface":
+		Init()
+		err := cmd.LocalInterface(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "local-exclude-add":
+		Init()
+		err := cmd.LocalExcludeAdd(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "local-exclude-remove":
+		Init()
+		err := cmd.LocalExcludeRemove(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "default-local-exclude-on":
+		Init()
+		err := cmd.DefaultLocalExcludeOn()
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "default-local-exclude-off":
+		Init()
+		err := cmd.DefaultLocalExcludeOff()
+		if err != nil {
+			panic(err)
+		}
+		break
 	case "public-address":
 		Init()
 		err := cmd.PublicAddress(flag.Arg(1))
@@ -122,6 +257,27 @@ func main() {
 			panic(err)
 		}
 		break
+	case "discover-add":
+		Init()
+		err := cmd.DiscoverAdd(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "discover-remove":
+		Init()
+		err := cmd.DiscoverRemove(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "discover-consensus":
+		Init()
+		err := cmd.DiscoverConsensus(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
 	case "direct-ssh-on":
 		Init()
 		err := cmd.DirectSshOn()
@@ -136,6 +292,34 @@ func main() {
 			panic(err)
 		}
 		break
+	case "direct-exempt-add":
+		Init()
+		err := cmd.ExemptAdd(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "direct-exempt-remove":
+		Init()
+		err := cmd.ExemptRemove(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "default-exempt-on":
+		Init()
+		err := cmd.DefaultExemptOn()
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "default-exempt-off":
+		Init()
+		err := cmd.DefaultExemptOff()
+		if err != nil {
+			panic(err)
+		}
+		break
 	case "verify-on":
 		Init()
 		err := cmd.VerifyOn()
@@ -150,6 +334,76 @@ func main() {
 			panic(err)
 		}
 		break
+	case "tls-pin":
+		Init()
+		err := cmd.TlsPin(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "tls-ca":
+		Init()
+		err := cmd.TlsCa(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "tls-cert":
+		Init()
+		err := cmd.TlsCert(flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "tls-clear":
+		Init()
+		err := cmd.TlsClear(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "proxy":
+		Init()
+		err := cmd.Proxy(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "uri-proxy":
+		Init()
+		err := cmd.UriProxy(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "no-proxy-add":
+		Init()
+		err := cmd.NoProxyAdd(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "no-proxy-remove":
+		Init()
+		err := cmd.NoProxyRemove(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "legacy-cipher-on":
+		Init()
+		err := cmd.LegacyCipherOn()
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "legacy-cipher-off":
+		Init()
+		err := cmd.LegacyCipherOff()
+		if err != nil {
+			panic(err)
+		}
+		break
 	case "disconnected-timeout-on":
 		Init()
 		err := cmd.DisconnectedTimeoutOn()
@@ -185,6 +439,49 @@ func main() {
 			panic(err)
 		}
 		break
+	case "firewall":
+		Init()
+		err := cmd.Firewall(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "subnet-conflict":
+		Init()
+		err := cmd.SubnetConflict(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "static-add":
+		Init()
+		err := cmd.StaticAdd(flag.Arg(1), flag.Arg(2), flag.Arg(3),
+			flag.Arg(4), flag.Arg(5))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "static-remove":
+		Init()
+		err := cmd.StaticRemove(flag.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "static-option":
+		Init()
+		err := cmd.StaticOption(flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		if err != nil {
+			panic(err)
+		}
+		break
+	case "export-peer":
+		Init()
+		err := cmd.ExportPeer(flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		if err != nil {
+			panic(err)
+		}
+		break
 	case "unifi-username":
 		Init()
 		err := cmd.UnifiUsername(flag.Arg(1))
@@ -277,6 +574,6 @@ func main() {
 		}
 		break
 	default:
-		fmt.Println(help)
+		fmt.Print(help)
 	}
 }
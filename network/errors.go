@@ -0,0 +1,51 @@
+package network
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/vishvananda/netlink"
+	"syscall"
+)
+
+func parseError(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		return &errortypes.NotFoundError{
+			errors.Wrap(err, msg+", no such device"),
+		}
+	}
+
+	if errno, ok := err.(syscall.Errno); ok {
+		switch errno {
+		case syscall.EEXIST:
+			return &errortypes.ExistsError{
+				errors.Wrap(err, msg+", already exists"),
+			}
+		case syscall.ENODEV:
+			return &errortypes.NotFoundError{
+				errors.Wrap(err, msg+", no such device"),
+			}
+		case syscall.ESRCH, syscall.ENOENT:
+			return &errortypes.NotFoundError{
+				errors.Wrap(err, msg+", not found"),
+			}
+		}
+	}
+
+	return &errortypes.ExecError{
+		errors.Wrap(err, msg),
+	}
+}
+
+func IsExists(err error) bool {
+	_, ok := err.(*errortypes.ExistsError)
+	return ok
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*errortypes.NotFoundError)
+	return ok
+}
@@ -0,0 +1,152 @@
+package network
+
+import (
+	"context"
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-link/utils"
+	"github.com/vishvananda/netlink"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	eventDebounce    = 500 * time.Millisecond
+	eventDebounceMax = 5 * time.Second
+	eventRetry       = 10 * time.Second
+)
+
+var (
+	watching     = false
+	watchingLock sync.Mutex
+)
+
+func setWatching(val bool) {
+	watchingLock.Lock()
+	watching = val
+	watchingLock.Unlock()
+}
+
+// Netlink subscriptions are active, polling can be relaxed
+func IsWatching() bool {
+	watchingLock.Lock()
+	defer watchingLock.Unlock()
+	return watching
+}
+
+func subscribe(ctx context.Context, changed chan<- bool) (err error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	linkCh := make(chan netlink.LinkUpdate, 64)
+	addrCh := make(chan netlink.AddrUpdate, 64)
+	routeCh := make(chan netlink.RouteUpdate, 64)
+
+	err = netlink.LinkSubscribe(linkCh, done)
+	if err != nil {
+		err = parseError(err, "network: Failed to subscribe to link events")
+		return
+	}
+
+	err = netlink.AddrSubscribe(addrCh, done)
+	if err != nil {
+		err = parseError(err, "network: Failed to subscribe to addr events")
+		return
+	}
+
+	err = netlink.RouteSubscribe(routeCh, done)
+	if err != nil {
+		err = parseError(err, "network: Failed to subscribe to route events")
+		return
+	}
+
+	setWatching(true)
+	defer setWatching(false)
+
+	// Debounce fires after events stop for the debounce period, a
+	// continuous stream of events is flushed after the max period
+	debounce := time.NewTimer(eventDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	pending := false
+	first := time.Time{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-linkCh:
+			if !ok {
+				return
+			}
+			break
+		case _, ok := <-addrCh:
+			if !ok {
+				return
+			}
+			break
+		case update, ok := <-routeCh:
+			if !ok {
+				return
+			}
+
+			// Ignore policy routing tables managed by the link
+			if update.Table != syscall.RT_TABLE_MAIN {
+				continue
+			}
+			break
+		case <-debounce.C:
+			pending = false
+
+			select {
+			case changed <- true:
+			default:
+			}
+
+			continue
+		}
+
+		if !pending {
+			pending = true
+			first = time.Now()
+		} else if !debounce.Stop() {
+			<-debounce.C
+		}
+
+		delay := eventDebounce
+		if remaining := eventDebounceMax - time.Since(first); remaining < delay {
+			delay = remaining
+		}
+		debounce.Reset(delay)
+	}
+}
+
+// Watch link, address and main table route changes until the context is
+// done, a value is sent on the returned channel after events settle
+func Watch(ctx context.Context) (changed chan bool) {
+	changed = make(chan bool, 1)
+
+	go func() {
+		for {
+			err := subscribe(ctx, changed)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+				}).Warn("network: Network event subscription failed, " +
+					"falling back to polling")
+			} else {
+				logrus.Warn("network: Network event subscription closed")
+			}
+
+			if !utils.Sleep(ctx, eventRetry) {
+				return
+			}
+		}
+	}()
+
+	return
+}
@@ -0,0 +1,118 @@
+// Netlink management of links, addresses and routes.
+package network
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/vishvananda/netlink"
+	"net"
+)
+
+func GreAdd(name, local, remote string) (err error) {
+	localIp := net.ParseIP(local)
+	remoteIp := net.ParseIP(remote)
+	if localIp == nil || remoteIp == nil {
+		err = &errortypes.ParseError{
+			errors.Newf("network: Invalid tunnel address %s %s",
+				local, remote),
+		}
+		return
+	}
+
+	link := &netlink.Gretun{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: name,
+		},
+		Local:  localIp,
+		Remote: remoteIp,
+	}
+
+	err = parseError(netlink.LinkAdd(link),
+		"network: Failed to add gre tunnel")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GreGet(name string) (local, remote string, err error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		err = parseError(err, "network: Failed to get link")
+		return
+	}
+
+	gre, ok := link.(*netlink.Gretun)
+	if !ok {
+		err = &errortypes.NotFoundError{
+			errors.Newf("network: Link %s is not a gre tunnel", name),
+		}
+		return
+	}
+
+	if gre.Local != nil {
+		local = gre.Local.String()
+	}
+	if gre.Remote != nil {
+		remote = gre.Remote.String()
+	}
+
+	return
+}
+
+func LinkUp(name string) (err error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		err = parseError(err, "network: Failed to get link")
+		return
+	}
+
+	err = parseError(netlink.LinkSetUp(link),
+		"network: Failed to set link up")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func LinkDel(name string) (err error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		err = parseError(err, "network: Failed to get link")
+		return
+	}
+
+	err = parseError(netlink.LinkDel(link),
+		"network: Failed to delete link")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func AddrAdd(name, cidr string) (err error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		err = parseError(err, "network: Failed to get link")
+		return
+	}
+
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrapf(err, "network: Failed to parse address %s", cidr),
+		}
+		return
+	}
+
+	err = parseError(netlink.AddrAdd(link, addr),
+		"network: Failed to add address")
+	if err != nil {
+		return
+	}
+
+	return
+}
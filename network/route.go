@@ -0,0 +1,176 @@
+package network
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/vishvananda/netlink"
+	"net"
+	"strings"
+	"syscall"
+)
+
+func parseDst(dst string) (network *net.IPNet, err error) {
+	if !strings.Contains(dst, "/") {
+		if strings.Contains(dst, ":") {
+			dst += "/128"
+		} else {
+			dst += "/32"
+		}
+	}
+
+	_, network, err = net.ParseCIDR(dst)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrapf(err, "network: Failed to parse route %s", dst),
+		}
+		return
+	}
+
+	return
+}
+
+func getRoute(table int, dst, gateway, iface string) (
+	route *netlink.Route, err error) {
+
+	route = &netlink.Route{
+		Table: table,
+	}
+
+	route.Dst, err = parseDst(dst)
+	if err != nil {
+		return
+	}
+
+	if gateway != "" {
+		route.Gw = net.ParseIP(gateway)
+		if route.Gw == nil {
+			err = &errortypes.ParseError{
+				errors.Newf("network: Invalid gateway %s", gateway),
+			}
+			return
+		}
+	}
+
+	if iface != "" {
+		link, e := netlink.LinkByName(iface)
+		if e != nil {
+			err = parseError(e, "network: Failed to get link")
+			return
+		}
+		route.LinkIndex = link.Attrs().Index
+	}
+
+	return
+}
+
+func RouteAdd(dst, gateway, iface string) (err error) {
+	err = TableRouteAdd(syscall.RT_TABLE_MAIN, dst, gateway, iface)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RouteDel(dst, gateway, iface string) (err error) {
+	err = TableRouteDel(syscall.RT_TABLE_MAIN, dst, gateway, iface)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func TableRouteAdd(table int, dst, gateway, iface string) (err error) {
+	route, err := getRoute(table, dst, gateway, iface)
+	if err != nil {
+		return
+	}
+
+	err = parseError(netlink.RouteAdd(route),
+		"network: Failed to add route")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func TableRouteDel(table int, dst, gateway, iface string) (err error) {
+	route, err := getRoute(table, dst, gateway, iface)
+	if err != nil {
+		return
+	}
+
+	err = parseError(netlink.RouteDel(route),
+		"network: Failed to delete route")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func TableFlush(table int) (err error) {
+	routes, err := netlink.RouteListFiltered(
+		netlink.FAMILY_ALL,
+		&netlink.Route{
+			Table: table,
+		},
+		netlink.RT_FILTER_TABLE,
+	)
+	if err != nil {
+		err = parseError(err, "network: Failed to list routes")
+		return
+	}
+
+	for _, route := range routes {
+		e := netlink.RouteDel(&route)
+		if e != nil && !IsNotFound(parseError(e, "")) {
+			err = parseError(e, "network: Failed to delete route")
+			return
+		}
+	}
+
+	return
+}
+
+func GetDefaultRoute() (iface, gateway string, err error) {
+	routes, err := netlink.RouteListFiltered(
+		netlink.FAMILY_V4,
+		&netlink.Route{
+			Table: syscall.RT_TABLE_MAIN,
+		},
+		netlink.RT_FILTER_TABLE,
+	)
+	if err != nil {
+		err = parseError(err, "network: Failed to list routes")
+		return
+	}
+
+	for _, route := range routes {
+		if route.Dst != nil {
+			ones, _ := route.Dst.Mask.Size()
+			if ones != 0 {
+				continue
+			}
+		}
+
+		if route.Gw != nil {
+			gateway = route.Gw.String()
+		}
+
+		if route.LinkIndex != 0 {
+			link, e := netlink.LinkByIndex(route.LinkIndex)
+			if e != nil {
+				err = parseError(e, "network: Failed to get link")
+				return
+			}
+			iface = link.Attrs().Name
+		}
+
+		return
+	}
+
+	return
+}
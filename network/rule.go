@@ -0,0 +1,127 @@
+package network
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+type Rule struct {
+	Priority        int
+	Table           int
+	Destination     string
+	Mark            int
+	SuppressDefault bool
+}
+
+func getNlRule(rule *Rule) (nlRule *netlink.Rule, err error) {
+	nlRule = netlink.NewRule()
+	nlRule.Priority = rule.Priority
+	nlRule.Table = rule.Table
+
+	if rule.Destination != "" {
+		nlRule.Dst, err = parseDst(rule.Destination)
+		if err != nil {
+			return
+		}
+	}
+
+	if rule.Mark != 0 {
+		mask := uint32(0xffffffff)
+		nlRule.Mark = uint32(rule.Mark)
+		nlRule.Mask = &mask
+	}
+
+	if rule.SuppressDefault {
+		nlRule.SuppressPrefixlen = 0
+	}
+
+	return
+}
+
+func RuleAdd(rule *Rule) (err error) {
+	nlRule, err := getNlRule(rule)
+	if err != nil {
+		return
+	}
+
+	err = parseError(netlink.RuleAdd(nlRule),
+		"network: Failed to add rule")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RuleDel(rule *Rule) (err error) {
+	nlRule, err := getNlRule(rule)
+	if err != nil {
+		return
+	}
+
+	err = parseError(netlink.RuleDel(nlRule),
+		"network: Failed to delete rule")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RuleClear(minPriority, maxPriority int) (err error) {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		rules, e := netlink.RuleList(family)
+		if e != nil {
+			err = parseError(e, "network: Failed to list rules")
+			return
+		}
+
+		for _, rule := range rules {
+			if rule.Priority < minPriority || rule.Priority > maxPriority {
+				continue
+			}
+
+			e = netlink.RuleDel(&rule)
+			if e != nil && !IsNotFound(parseError(e, "")) {
+				err = parseError(e, "network: Failed to delete rule")
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func RuleList(minPriority, maxPriority int) (rules []*Rule, err error) {
+	rules = []*Rule{}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		nlRules, e := netlink.RuleList(family)
+		if e != nil {
+			err = parseError(e, "network: Failed to list rules")
+			return
+		}
+
+		for _, nlRule := range nlRules {
+			if nlRule.Priority < minPriority ||
+				nlRule.Priority > maxPriority {
+
+				continue
+			}
+
+			rule := &Rule{
+				Priority:        nlRule.Priority,
+				Table:           nlRule.Table,
+				Mark:            int(nlRule.Mark),
+				SuppressDefault: nlRule.SuppressPrefixlen == 0,
+			}
+
+			if nlRule.Dst != nil {
+				rule.Destination = nlRule.Dst.String()
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,140 @@
+// Proxy selection for outbound HTTP requests.
+package proxy
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/constants"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const Direct = "direct"
+
+var defaultNoProxy = []string{
+	"localhost",
+	"127.0.0.0/8",
+	"::1",
+	"169.254.0.0/16",
+	"metadata.google.internal",
+}
+
+func matchNoProxy(host, entry string) bool {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if entry == "" {
+		return false
+	}
+
+	if entry == "*" {
+		return true
+	}
+
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return false
+		}
+
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+
+	entry = strings.TrimPrefix(entry, "*")
+	if strings.HasPrefix(entry, ".") {
+		return strings.HasSuffix(host, entry) || host == entry[1:]
+	}
+
+	return host == entry || strings.HasSuffix(host, "."+entry)
+}
+
+func IsNoProxy(host string) bool {
+	host = strings.ToLower(host)
+
+	for _, entry := range defaultNoProxy {
+		if matchNoProxy(host, entry) {
+			return true
+		}
+	}
+
+	for _, entry := range config.Config.NoProxy {
+		if matchNoProxy(host, entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Parse(proxy string) (proxyUrl *url.URL, err error) {
+	proxyUrl, err = config.ParseProxy(proxy)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// Proxy for requests to a Pritunl server uri, empty uri for other
+// requests. Order is no proxy list, uri proxy, configured proxy then
+// environment variables.
+func GetProxy(uri string, req *http.Request) (proxyUrl *url.URL,
+	err error) {
+
+	if IsNoProxy(req.URL.Hostname()) {
+		return
+	}
+
+	proxy := ""
+	if uri != "" {
+		if uriData := config.Config.GetUri(uri); uriData != nil {
+			proxy = uriData.Proxy
+		}
+	}
+	if proxy == "" {
+		proxy = config.Config.Proxy
+	}
+
+	if proxy == Direct {
+		return
+	} else if proxy != "" {
+		proxyUrl, err = Parse(proxy)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	proxyUrl, err = http.ProxyFromEnvironment(req)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "proxy: Failed to parse environment proxy"),
+		}
+		return
+	}
+
+	return
+}
+
+func Func(uri string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		return GetProxy(uri, req)
+	}
+}
+
+// Transport with proxy support, https requests are tunneled with CONNECT
+// and proxy credentials are taken from the proxy url. Dial and handshake
+// are bounded with the same timeout as state requests.
+func NewTransport(uri string) *http.Transport {
+	return &http.Transport{
+		Proxy: Func(uri),
+		DialContext: (&net.Dialer{
+			Timeout:   constants.StateRequestTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: constants.StateRequestTimeout,
+	}
+}
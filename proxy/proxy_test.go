@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestMatchNoProxy(t *testing.T) {
+	tests := []struct {
+		host  string
+		entry string
+		match bool
+	}{
+		{"example.com", "", false},
+		{"example.com", "  ", false},
+		{"example.com", "*", true},
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.COM", true},
+		{"api.example.com", "example.com", true},
+		{"badexample.com", "example.com", false},
+		{"api.example.com", ".example.com", true},
+		{"example.com", ".example.com", true},
+		{"api.example.com", "*.example.com", true},
+		{"badexample.com", "*.example.com", false},
+		{"10.1.2.3", "10.0.0.0/8", true},
+		{"11.1.2.3", "10.0.0.0/8", false},
+		{"example.com", "10.0.0.0/8", false},
+		{"10.1.2.3", "10.0.0.0/33", false},
+		{"fd00::1", "fd00::/8", true},
+		{"10.1.2.3", "10.1.2.3", true},
+	}
+
+	for _, test := range tests {
+		match := matchNoProxy(test.host, test.entry)
+		if match != test.match {
+			t.Errorf("matchNoProxy(%q, %q) = %t, expected %t",
+				test.host, test.entry, match, test.match)
+		}
+	}
+}
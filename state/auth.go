@@ -0,0 +1,40 @@
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"github.com/pritunl/pritunl-link/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func AuthRequest(req *http.Request, uriData *url.URL) (nonce string) {
+	hostId := uriData.User.Username()
+	hostSecret, _ := uriData.User.Password()
+	timestamp := strconv.FormatInt(
+		getServerTime(uriData.Host).Unix(), 10)
+	nonce = utils.RandStr(32)
+
+	authStr := strings.Join([]string{
+		hostId,
+		timestamp,
+		nonce,
+		req.Method,
+		req.URL.Path,
+	}, "&")
+
+	hashFunc := hmac.New(sha512.New, []byte(hostSecret))
+	hashFunc.Write([]byte(authStr))
+	rawSignature := hashFunc.Sum(nil)
+	sig := base64.StdEncoding.EncodeToString(rawSignature)
+
+	req.Header.Set("Auth-Token", hostId)
+	req.Header.Set("Auth-Timestamp", timestamp)
+	req.Header.Set("Auth-Nonce", nonce)
+	req.Header.Set("Auth-Signature", sig)
+
+	return
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"github.com/pritunl/pritunl-link/constants"
+	"math/rand"
+	"time"
+)
+
+type backoff struct {
+	Failures int
+	Next     time.Time
+}
+
+func checkBackoff(uri string) bool {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	bkoff, ok := backoffs[uri]
+	if !ok {
+		return false
+	}
+
+	return time.Now().Before(bkoff.Next)
+}
+
+func failBackoff(uri string) (delay time.Duration) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	bkoff, ok := backoffs[uri]
+	if !ok {
+		bkoff = &backoff{}
+		backoffs[uri] = bkoff
+	}
+
+	delay = constants.StateBackoffMin << uint(bkoff.Failures)
+	if delay > constants.StateBackoffMax || delay <= 0 {
+		delay = constants.StateBackoffMax
+	} else {
+		bkoff.Failures += 1
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay) / 2))
+	delay = delay/2 + jitter
+
+	bkoff.Next = time.Now().Add(delay)
+
+	return
+}
+
+func resetBackoff(uri string) {
+	cacheLock.Lock()
+	delete(backoffs, uri)
+	cacheLock.Unlock()
+}
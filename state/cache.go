@@ -0,0 +1,325 @@
+package state
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/constants"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/utils"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	diskCacheHashes = map[string]string{}
+	diskCacheTimes  = map[string]time.Time{}
+	cachedUris      = map[string]time.Time{}
+)
+
+type diskCache struct {
+	Timestamp time.Time `json:"timestamp"`
+	State     *State    `json:"state"`
+}
+
+func getDiskCachePath(uri string) string {
+	hash := sha256.Sum256([]byte(uri))
+	return path.Join(constants.StateCachePath,
+		fmt.Sprintf("%s.cache", hex.EncodeToString(hash[:])))
+}
+
+func getDiskCacheCipher(uri string) (aead cipher.AEAD, err error) {
+	uriData, err := url.ParseRequestURI(uri)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to parse uri"),
+		}
+		return
+	}
+
+	hostSecret, _ := uriData.User.Password()
+
+	hashFunc := hmac.New(sha256.New, []byte(hostSecret))
+	hashFunc.Write([]byte("pritunl-link-state-cache"))
+	key := hashFunc.Sum(nil)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to load cache cipher"),
+		}
+		return
+	}
+
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to load cache cipher"),
+		}
+		return
+	}
+
+	return
+}
+
+func getMaxStaleness() time.Duration {
+	maxAge := config.Config.StateCacheMaxAge
+	if maxAge != 0 {
+		return time.Duration(maxAge) * time.Second
+	}
+	return constants.DefaultStateCacheMaxAge
+}
+
+func GetCachedUris() (uris map[string]time.Time) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	uris = map[string]time.Time{}
+	for uri, timestamp := range cachedUris {
+		uris[uri] = timestamp
+	}
+
+	return
+}
+
+func saveDiskCache(uri string, state *State) (err error) {
+	cacheLock.Lock()
+	curHash := diskCacheHashes[uri]
+	curTime := diskCacheTimes[uri]
+	cacheLock.Unlock()
+
+	// Unchanged states are rewritten periodically to keep the cache
+	// timestamp within the max staleness
+	if curHash == state.Hash &&
+		time.Since(curTime) < constants.StateCacheRefresh {
+
+		return
+	}
+
+	aead, err := getDiskCacheCipher(uri)
+	if err != nil {
+		return
+	}
+
+	data, err := json.Marshal(&diskCache{
+		Timestamp: time.Now(),
+		State:     state,
+	})
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to marshal state cache"),
+		}
+		return
+	}
+
+	nonce, err := utils.RandBytes(aead.NonceSize())
+	if err != nil {
+		return
+	}
+
+	encData := aead.Seal(nonce, nonce, data, []byte(uri))
+
+	err = utils.ExistsMkdir(constants.StateCachePath, 0700)
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(getDiskCachePath(uri), encData, 0600)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "state: Failed to write state cache"),
+		}
+		return
+	}
+
+	cacheLock.Lock()
+	diskCacheHashes[uri] = state.Hash
+	diskCacheTimes[uri] = time.Now()
+	cacheLock.Unlock()
+
+	return
+}
+
+func readDiskCache(uri string) (cache *diskCache, err error) {
+	encData, err := ioutil.ReadFile(getDiskCachePath(uri))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+			return
+		}
+
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "state: Failed to read state cache"),
+		}
+		return
+	}
+
+	aead, err := getDiskCacheCipher(uri)
+	if err != nil {
+		return
+	}
+
+	if len(encData) < aead.NonceSize() {
+		err = &errortypes.ParseError{
+			errors.New("state: Invalid state cache"),
+		}
+		return
+	}
+
+	nonce := encData[:aead.NonceSize()]
+	data, err := aead.Open(nil, nonce, encData[aead.NonceSize():],
+		[]byte(uri))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to decrypt state cache"),
+		}
+		return
+	}
+
+	cacheData := &diskCache{}
+	err = json.Unmarshal(data, cacheData)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to unmarshal state cache"),
+		}
+		return
+	}
+
+	cache = cacheData
+
+	return
+}
+
+func loadDiskCache(uri string) (state *State, timestamp time.Time,
+	err error) {
+
+	cache, err := readDiskCache(uri)
+	if err != nil || cache == nil {
+		return
+	}
+
+	if time.Since(cache.Timestamp) > getMaxStaleness() {
+		return
+	}
+
+	state = cache.State
+	timestamp = cache.Timestamp
+
+	return
+}
+
+func getDiskCache(uri string) (state *State) {
+	state, timestamp, err := loadDiskCache(uri)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("state: Failed to load state cache")
+		return
+	}
+
+	if state == nil {
+		return
+	}
+
+	cacheLock.Lock()
+	_, exists := cachedUris[uri]
+	cachedUris[uri] = timestamp
+	cacheLock.Unlock()
+
+	if !exists {
+		logrus.WithFields(logrus.Fields{
+			"state_id":  state.Id,
+			"cache_age": utils.ToFixed(time.Since(timestamp).Hours(), 2),
+		}).Warn("state: Server unreachable, running from cached state")
+	}
+
+	return
+}
+
+// States last saved by the running service, used by commands that
+// must not send requests to the Pritunl servers
+func GetDiskStates() (states []*State) {
+	states = []*State{}
+
+	for _, uri := range config.Config.GetUris() {
+		cache, err := readDiskCache(uri)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("state: Failed to load state cache")
+			continue
+		}
+
+		if cache != nil && cache.State != nil {
+			states = append(states, cache.State)
+		}
+	}
+
+	states = append(states, GetStaticStates()...)
+
+	return
+}
+
+// Remove cache files left by uris removed or disabled since the last run
+func CleanDiskCache() {
+	paths := set.NewSet()
+	for _, uri := range config.Config.GetUris() {
+		paths.Add(getDiskCachePath(uri))
+	}
+
+	files, err := ioutil.ReadDir(constants.StateCachePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("state: Failed to read state cache directory")
+		}
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".cache") {
+			continue
+		}
+
+		cachePath := path.Join(constants.StateCachePath, file.Name())
+		if paths.Contains(cachePath) {
+			continue
+		}
+
+		err = os.Remove(cachePath)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"path":  cachePath,
+				"error": err,
+			}).Warn("state: Failed to remove unused state cache")
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"path": cachePath,
+		}).Info("state: Removed unused state cache")
+	}
+}
+
+func clearDiskCache(uri string) {
+	cacheLock.Lock()
+	delete(diskCacheHashes, uri)
+	delete(diskCacheTimes, uri)
+	delete(cachedUris, uri)
+	cacheLock.Unlock()
+
+	os.Remove(getDiskCachePath(uri))
+}
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"golang.org/x/crypto/chacha20poly1305"
+	"strings"
+	"time"
+)
+
+const (
+	CipherAesGcm   = "aes-256-gcm"
+	CipherChacha20 = "chacha20-poly1305"
+)
+
+func getAead(secret, mode string) (aead cipher.AEAD, err error) {
+	hashFunc := hmac.New(sha256.New, []byte(secret))
+	hashFunc.Write([]byte("pritunl-link-state-response"))
+	key := hashFunc.Sum(nil)
+
+	switch mode {
+	case CipherAesGcm:
+		block, e := aes.NewCipher(key)
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "state: Failed to load cipher"),
+			}
+			return
+		}
+
+		aead, err = cipher.NewGCM(block)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "state: Failed to load cipher"),
+			}
+			return
+		}
+
+		break
+	case CipherChacha20:
+		aead, err = chacha20poly1305.New(key)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "state: Failed to load cipher"),
+			}
+			return
+		}
+
+		break
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("state: Unknown cipher mode '%s'", mode),
+		}
+		return
+	}
+
+	return
+}
+
+func decRespAead(host, hostId, secret, mode, nonce, timestamp, reqNonce,
+	encData string, dateOffset time.Duration) (data []byte, err error) {
+
+	aead, err := getAead(secret, mode)
+	if err != nil {
+		return
+	}
+
+	err = checkRespTimestamp(host, timestamp, dateOffset)
+	if err != nil {
+		return
+	}
+
+	cipNonce, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to decode cipher nonce"),
+		}
+		return
+	}
+
+	if len(cipNonce) != aead.NonceSize() {
+		err = &errortypes.ParseError{
+			errors.New("state: Invalid cipher nonce"),
+		}
+		return
+	}
+
+	cipData, err := base64.StdEncoding.DecodeString(encData)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to decode response data"),
+		}
+		return
+	}
+
+	authData := strings.Join([]string{
+		hostId,
+		timestamp,
+		reqNonce,
+	}, "&")
+
+	data, err = aead.Open(nil, cipNonce, cipData, []byte(authData))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Cipher data authentication failed"),
+		}
+		return
+	}
+
+	return
+}
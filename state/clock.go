@@ -0,0 +1,254 @@
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/constants"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	clockOffsets = map[string]time.Duration{}
+	clockSkewed  = map[string]bool{}
+)
+
+func getClockOffset(host string) (offset time.Duration) {
+	cacheLock.Lock()
+	offset = clockOffsets[host]
+	cacheLock.Unlock()
+	return
+}
+
+func getServerTime(host string) time.Time {
+	return time.Now().Add(getClockOffset(host))
+}
+
+func boundOffset(offset, max time.Duration) time.Duration {
+	if offset > max {
+		return max
+	} else if offset < -max {
+		return -max
+	}
+	return offset
+}
+
+func absOffset(offset time.Duration) time.Duration {
+	if offset < 0 {
+		return -offset
+	}
+	return offset
+}
+
+// Offset from the unauthenticated Date header, callers must bound the
+// offset before using it
+func getDateOffset(res *http.Response, start time.Time) (
+	offset time.Duration) {
+
+	date := res.Header.Get("Date")
+	if date == "" {
+		return
+	}
+
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+
+	end := time.Now()
+	localTime := start.Add(end.Sub(start) / 2)
+
+	// Date header has one second resolution, ignore smaller offsets
+	offset = serverTime.Sub(localTime)
+	if offset > -time.Second && offset < time.Second {
+		offset = 0
+	}
+	offset = offset.Truncate(time.Second)
+
+	return
+}
+
+// Log the offset from a response that could not be authenticated
+func warnClockSkew(host string, offset time.Duration) {
+	if absOffset(offset) <= constants.StateClockSkewWarn {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"host":   host,
+		"offset": offset.String(),
+	}).Warn("state: Local clock differs from server clock, " +
+		"check system time synchronization")
+}
+
+// Use the bounded offset from the Date header of a rejected request until
+// the next request is authenticated, a far off local clock is rejected by
+// the server and would never receive a response to correct the offset
+func setProvisionalOffset(host string, offset time.Duration) (
+	prevOffset time.Duration, changed bool) {
+
+	offset = boundOffset(offset, constants.StateClockProvisionalMax)
+
+	cacheLock.Lock()
+	prevOffset = clockOffsets[host]
+	if absOffset(offset-prevOffset) > constants.StateClockSkewWarn {
+		clockOffsets[host] = offset
+		changed = true
+	}
+	cacheLock.Unlock()
+
+	if changed {
+		logrus.WithFields(logrus.Fields{
+			"host":   host,
+			"offset": offset.String(),
+		}).Warn("state: Request rejected with local clock skew, " +
+			"retrying with server clock offset")
+	}
+
+	return
+}
+
+func restoreClockOffset(host string, offset time.Duration) {
+	cacheLock.Lock()
+	clockOffsets[host] = offset
+	cacheLock.Unlock()
+}
+
+// Store the offset, must only be called after the response has been
+// authenticated
+func updateClockOffset(host string, offset time.Duration) {
+	offset = boundOffset(offset, constants.StateClockProvisionalMax)
+	skewed := absOffset(offset) > constants.StateClockSkewWarn
+
+	cacheLock.Lock()
+	clockOffsets[host] = offset
+	wasSkewed := clockSkewed[host]
+	clockSkewed[host] = skewed
+	cacheLock.Unlock()
+
+	if skewed {
+		SetError("clock_skew_"+host, SeverityWarning,
+			fmt.Sprintf("Local clock differs from server clock by %s",
+				offset.String()), nil)
+	} else if wasSkewed {
+		ClearError("clock_skew_" + host)
+	}
+
+	if skewed && !wasSkewed {
+		logrus.WithFields(logrus.Fields{
+			"host":   host,
+			"offset": offset.String(),
+		}).Warn("state: Local clock differs from server clock, " +
+			"check system time synchronization")
+	} else if !skewed && wasSkewed {
+		logrus.WithFields(logrus.Fields{
+			"host":   host,
+			"offset": offset.String(),
+		}).Info("state: Local clock synchronized with server clock")
+	}
+}
+
+// Remove offsets and skew reports of hosts no longer configured
+func cleanClockOffsets(hosts set.Set) {
+	cacheLock.Lock()
+	for host := range clockOffsets {
+		if !hosts.Contains(host) {
+			delete(clockOffsets, host)
+		}
+	}
+	for host := range clockSkewed {
+		if !hosts.Contains(host) {
+			delete(clockSkewed, host)
+		}
+	}
+	cacheLock.Unlock()
+
+	for _, report := range GetReports() {
+		if !strings.HasPrefix(report.Source, "clock_skew_") {
+			continue
+		}
+
+		host := strings.TrimPrefix(report.Source, "clock_skew_")
+		if !hosts.Contains(host) {
+			ClearError(report.Source)
+		}
+	}
+}
+
+func checkRespAge(serverTime time.Time, timestamp int64) bool {
+	respAge := serverTime.Sub(time.Unix(timestamp, 0))
+	if respAge < 0 {
+		respAge = -respAge
+	}
+	return respAge <= constants.StateResponseMaxAge
+}
+
+// Check the response timestamp against the established offset or the
+// bounded offset from the Date header of this response
+func checkRespTimestamp(host, timestamp string,
+	dateOffset time.Duration) (err error) {
+
+	timestampInt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to parse response timestamp"),
+		}
+		return
+	}
+
+	if !checkRespAge(getServerTime(host), timestampInt) &&
+		!checkRespAge(time.Now().Add(dateOffset), timestampInt) {
+
+		err = &errortypes.ParseError{
+			errors.New("state: Response timestamp outside allowed window"),
+		}
+		return
+	}
+
+	return
+}
+
+func checkRespAuth(host, hostId, secret, timestamp, nonce, reqNonce,
+	sig, cipherSig string, dateOffset time.Duration) (err error) {
+
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(reqNonce)) != 1 {
+		err = &errortypes.ParseError{
+			errors.New("state: Response nonce does not match request"),
+		}
+		return
+	}
+
+	authStr := strings.Join([]string{
+		hostId,
+		timestamp,
+		nonce,
+		cipherSig,
+	}, "&")
+
+	hashFunc := hmac.New(sha512.New, []byte(secret))
+	hashFunc.Write([]byte(authStr))
+	rawSignature := hashFunc.Sum(nil)
+	testSig := base64.StdEncoding.EncodeToString(rawSignature)
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(testSig)) != 1 {
+		err = &errortypes.ParseError{
+			errors.New("state: Response signature invalid"),
+		}
+		return
+	}
+
+	err = checkRespTimestamp(host, timestamp, dateOffset)
+	if err != nil {
+		return
+	}
+
+	return
+}
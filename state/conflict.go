@@ -0,0 +1,47 @@
+package state
+
+import (
+	"github.com/pritunl/pritunl-link/status"
+	"strings"
+	"sync"
+)
+
+var (
+	conflictLock  sync.Mutex
+	conflictLinks = map[string]bool{}
+)
+
+// Set links not deployed because all right subnets conflict
+func SetConflicts(links []string) {
+	conflicts := map[string]bool{}
+	for _, link := range links {
+		conflicts[link] = true
+	}
+
+	conflictLock.Lock()
+	conflictLinks = conflicts
+	conflictLock.Unlock()
+}
+
+func IsConflict(link string) bool {
+	conflictLock.Lock()
+	defer conflictLock.Unlock()
+	return conflictLinks[link]
+}
+
+func AddConflictStatus(stats status.Status) {
+	conflictLock.Lock()
+	defer conflictLock.Unlock()
+
+	for link := range conflictLinks {
+		linkId := strings.SplitN(link, "-", 2)
+		if len(linkId) != 2 {
+			continue
+		}
+
+		if _, ok := stats[linkId[0]]; !ok {
+			stats[linkId[0]] = map[string]string{}
+		}
+		stats[linkId[0]][linkId[1]] = "conflict"
+	}
+}
@@ -3,4 +3,16 @@ package state
 const (
 	DirectServer = "direct_server"
 	DirectClient = "direct_client"
+	Static       = "static"
+	StaticPrefix = "static_"
+
+	CapNotify = "notify"
+	CapAead   = "aead"
+)
+
+var (
+	Capabilities = []string{
+		CapNotify,
+		CapAead,
+	}
 )
@@ -0,0 +1,183 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/constants"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/utils"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	Notify          = make(chan bool, 1)
+	notifyUris      = map[string]string{}
+	notifyListeners = map[string]bool{}
+)
+
+func getNotifyHash(uri string) (hash string, ok bool) {
+	cacheLock.Lock()
+	hash, ok = notifyUris[uri]
+	cacheLock.Unlock()
+	return
+}
+
+func IsNotifyAll() bool {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	uris := config.Config.GetUris()
+	if len(uris) == 0 {
+		return false
+	}
+
+	for _, uri := range uris {
+		if _, ok := notifyUris[uri]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func waitNotify(ctx context.Context, uri, hash string) (
+	changed bool, err error) {
+
+	uriData, err := url.ParseRequestURI(uri)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to parse uri"),
+		}
+		return
+	}
+
+	req, err := http.NewRequest(
+		"GET",
+		fmt.Sprintf("https://%s/link/state/notify", uriData.Host),
+		nil,
+	)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "state: Notify request init error"),
+		}
+		return
+	}
+
+	query := req.URL.Query()
+	query.Set("hash", hash)
+	req.URL.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(ctx, constants.StateNotifyTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	AuthRequest(req, uriData)
+
+	client, err := GetClient(uri)
+	if err != nil {
+		return
+	}
+
+	// Client timeout is shorter than the long poll, the request is
+	// bounded by the notify context instead
+	res, err := client.Transport.RoundTrip(req)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "state: Notify request error"),
+		}
+		return
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case 200:
+		changed = true
+		break
+	case 204, 304:
+		break
+	default:
+		err = &errortypes.RequestError{
+			errors.Newf("state: Bad status %d code from notify",
+				res.StatusCode),
+		}
+		return
+	}
+
+	return
+}
+
+func runNotifyListener(ctx context.Context, uri string) {
+	defer func() {
+		cacheLock.Lock()
+		delete(notifyListeners, uri)
+		cacheLock.Unlock()
+	}()
+
+	for {
+		hash, ok := getNotifyHash(uri)
+		if !ok {
+			return
+		}
+
+		changed, err := waitNotify(ctx, uri, hash)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("state: State notify failed, falling back to polling")
+
+			cacheLock.Lock()
+			delete(notifyUris, uri)
+			cacheLock.Unlock()
+
+			utils.Sleep(ctx, constants.StateNotifyRetry)
+
+			return
+		}
+
+		if changed {
+			select {
+			case Notify <- true:
+			default:
+			}
+
+			if !utils.Sleep(ctx, 1*time.Second) {
+				return
+			}
+		}
+	}
+}
+
+func RunNotify(ctx context.Context) {
+	waiter := sync.WaitGroup{}
+	defer waiter.Wait()
+
+	for {
+		if !utils.Sleep(ctx, 1*time.Second) {
+			return
+		}
+
+		cacheLock.Lock()
+		for uri := range notifyUris {
+			if !notifyListeners[uri] {
+				notifyListeners[uri] = true
+
+				waiter.Add(1)
+				go func(uri string) {
+					defer waiter.Done()
+					runNotifyListener(ctx, uri)
+				}(uri)
+			}
+		}
+		cacheLock.Unlock()
+	}
+}
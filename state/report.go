@@ -0,0 +1,119 @@
+package state
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+var (
+	reports    = map[string]*Report{}
+	reportLock sync.Mutex
+)
+
+type Report struct {
+	Source    string    `json:"source"`
+	Severity  string    `json:"severity"`
+	Message   string    `json:"message"`
+	Count     int       `json:"count"`
+	Timestamp time.Time `json:"timestamp"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Message of error including wrapped causes, without the stack trace
+// included by Error()
+func getErrorMessage(err error) string {
+	msgs := []string{}
+
+	for err != nil {
+		dbErr, ok := err.(errors.DropboxError)
+		if !ok {
+			msgs = append(msgs, err.Error())
+			break
+		}
+
+		msgs = append(msgs, dbErr.GetMessage())
+		err = dbErr.GetInner()
+	}
+
+	return strings.Join(msgs, ": ")
+}
+
+// Record an error for a source, reported to the server on each state
+// request until cleared by a successful run of the same source
+func SetError(source, severity, msg string, err error) {
+	if err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, getErrorMessage(err))
+	}
+
+	reportLock.Lock()
+	defer reportLock.Unlock()
+
+	now := time.Now()
+
+	report := reports[source]
+	if report != nil && report.Message == msg &&
+		report.Severity == severity {
+
+		report.Count += 1
+		report.LastSeen = now
+		return
+	}
+
+	reports[source] = &Report{
+		Source:    source,
+		Severity:  severity,
+		Message:   msg,
+		Count:     1,
+		Timestamp: now,
+		LastSeen:  now,
+	}
+}
+
+func ClearError(source string) {
+	reportLock.Lock()
+	report := reports[source]
+	delete(reports, source)
+	reportLock.Unlock()
+
+	if report != nil {
+		logrus.WithFields(logrus.Fields{
+			"source":  source,
+			"message": report.Message,
+		}).Info("state: Error resolved")
+	}
+}
+
+func GetReports() (reps []*Report) {
+	reportLock.Lock()
+	defer reportLock.Unlock()
+
+	reps = []*Report{}
+	for _, report := range reports {
+		rep := *report
+		reps = append(reps, &rep)
+	}
+
+	sort.Slice(reps, func(i, j int) bool {
+		return reps[i].Source < reps[j].Source
+	})
+
+	return
+}
+
+func getErrors(reps []*Report) (errs []string) {
+	errs = []string{}
+	for _, report := range reps {
+		errs = append(errs, report.Message)
+	}
+	return
+}
@@ -10,17 +10,19 @@ var (
 	LocalAddress     = ""
 	PublicAddress    = ""
 	Address6         = ""
-	Status           = map[string]map[string]string{}
+	PublicAddress6   = ""
 	IsDirectClient   = false
-	DirectIpsecState    *State
+	DirectIpsecState *State
 )
 
 type State struct {
-	Id     string  `json:"id"`
-	Type   string  `json:"type"`
-	Secret string  `json:"-"`
-	Hash   string  `json:"hash"`
-	Links  []*Link `json:"links"`
+	Id           string   `json:"id"`
+	Type         string   `json:"type"`
+	Priority     int      `json:"priority"`
+	Secret       string   `json:"-"`
+	Hash         string   `json:"hash"`
+	Capabilities []string `json:"capabilities"`
+	Links        []*Link  `json:"links"`
 }
 
 type Link struct {
@@ -28,6 +30,20 @@ type Link struct {
 	Right        string   `json:"right"`
 	LeftSubnets  []string `json:"left_subnets"`
 	RightSubnets []string `json:"right_subnets"`
+	LeftId       string   `json:"-"`
+	RightId      string   `json:"-"`
+	KeyExchange  string   `json:"-"`
+	Ike          string   `json:"-"`
+	Esp          string   `json:"-"`
+}
+
+func (s *State) HasCapability(name string) bool {
+	for _, capability := range s.Capabilities {
+		if capability == name {
+			return true
+		}
+	}
+	return false
 }
 
 func GetDefaultInterface() string {
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"io/ioutil"
+	"strings"
+)
+
+func GetStaticId(name string) string {
+	return StaticPrefix + name
+}
+
+func getStaticState(link *config.StaticLinkData) (stat *State, err error) {
+	pskData, err := ioutil.ReadFile(link.PskPath)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "state: Failed to read static link psk"),
+		}
+		return
+	}
+
+	psk := strings.TrimSpace(string(pskData))
+	if psk == "" {
+		err = &errortypes.ReadError{
+			errors.New("state: Static link psk is empty"),
+		}
+		return
+	}
+
+	// Secrets file quotes the psk without escaping
+	if strings.ContainsAny(psk, "\"\\\r\n") {
+		err = &errortypes.ParseError{
+			errors.New("state: Static link psk contains quote, " +
+				"backslash or newline"),
+		}
+		return
+	}
+
+	keyExchange := link.KeyExchange
+	if keyExchange == "" {
+		keyExchange = "ikev2"
+	}
+
+	stat = &State{
+		Id:   GetStaticId(link.Name),
+		Type: Static,
+		Links: []*Link{
+			&Link{
+				PreSharedKey: psk,
+				Right:        link.Right,
+				LeftSubnets:  link.LeftSubnets,
+				RightSubnets: link.RightSubnets,
+				LeftId:       link.LeftId,
+				RightId:      link.RightId,
+				KeyExchange:  keyExchange,
+				Ike:          link.Ike,
+				Esp:          link.Esp,
+			},
+		},
+	}
+
+	hashData, _ := json.Marshal(link)
+	hash := sha256.New()
+	hash.Write(hashData)
+	hash.Write([]byte(psk))
+	stat.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	return
+}
+
+// States for static links from config, deployed and monitored with the
+// states from Pritunl servers
+func GetStaticStates() (states []*State) {
+	states = []*State{}
+
+	for _, link := range config.Config.StaticLinks {
+		if link.Disabled {
+			continue
+		}
+
+		source := fmt.Sprintf("static_link_%s", link.Name)
+
+		stat, err := getStaticState(link)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"name":  link.Name,
+				"error": err,
+			}).Warn("state: Failed to load static link")
+
+			SetError(source, SeverityError,
+				"Static link "+link.Name+" failed to load", err)
+			continue
+		}
+		ClearError(source)
+
+		states = append(states, stat)
+	}
+
+	return
+}
@@ -6,13 +6,33 @@ import (
 	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/constants"
 	"github.com/pritunl/pritunl-link/status"
+	"sync"
 	"time"
 )
 
 var (
 	offlineTime time.Time
+	curStatus   = map[string]map[string]string{}
+	statusLock  sync.Mutex
 )
 
+// Copy of the link status from the last update
+func GetStatus() (stats map[string]map[string]string) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	stats = map[string]map[string]string{}
+	for stateId, conns := range curStatus {
+		stateConns := map[string]string{}
+		for connId, connStatus := range conns {
+			stateConns[connId] = connStatus
+		}
+		stats[stateId] = stateConns
+	}
+
+	return
+}
+
 func Update(names set.Set) (resetLinks []string, err error) {
 	resetLinks = []string{}
 
@@ -21,7 +41,11 @@ func Update(names set.Set) (resetLinks []string, err error) {
 		return
 	}
 
-	Status = stats
+	AddConflictStatus(stats)
+
+	statusLock.Lock()
+	curStatus = stats
+	statusLock.Unlock()
 
 	unknown := set.NewSet()
 	for stateId, conns := range stats {
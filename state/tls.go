@@ -0,0 +1,242 @@
+package state
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/proxy"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	clientsLock sync.Mutex
+	clients     = map[string]*uriClient{}
+)
+
+type uriClient struct {
+	key    string
+	client *http.Client
+}
+
+func ParsePin(pin string) (pinData []byte, err error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+
+	pinData, err = base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(pinData) != sha256.Size {
+		pinData, err = hex.DecodeString(strings.Replace(pin, ":", "", -1))
+	}
+	if err != nil || len(pinData) != sha256.Size {
+		pinData = nil
+		err = &errortypes.ParseError{
+			errors.New("state: Invalid SHA-256 public key pin"),
+		}
+		return
+	}
+
+	return
+}
+
+func getFileKey(pth string) string {
+	if pth == "" {
+		return ""
+	}
+
+	stat, err := os.Stat(pth)
+	if err != nil {
+		return pth
+	}
+
+	return fmt.Sprintf("%s:%d", pth, stat.ModTime().UnixNano())
+}
+
+func getTlsKey(tlsData *config.UriTlsData) string {
+	return strings.Join([]string{
+		fmt.Sprintf("%t", config.Config.SkipVerify),
+		tlsData.Pin,
+		getFileKey(tlsData.CaPath),
+		getFileKey(tlsData.CertPath),
+		getFileKey(tlsData.KeyPath),
+	}, "&")
+}
+
+// Per uri CA bundle and pin take precedence over the global skip verify
+func getTlsConfig(tlsData *config.UriTlsData, host string) (
+	tlsConf *tls.Config, err error) {
+
+	tlsConf = &tls.Config{
+		InsecureSkipVerify: config.Config.SkipVerify,
+	}
+
+	if tlsData.CaPath != "" {
+		caData, e := ioutil.ReadFile(tlsData.CaPath)
+		if e != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(e, "state: Failed to read CA bundle"),
+			}
+			return
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			err = &errortypes.ParseError{
+				errors.New("state: No certificates found in CA bundle"),
+			}
+			return
+		}
+
+		tlsConf.RootCAs = pool
+		tlsConf.InsecureSkipVerify = false
+	}
+
+	if tlsData.CertPath != "" || tlsData.KeyPath != "" {
+		cert, e := tls.LoadX509KeyPair(tlsData.CertPath, tlsData.KeyPath)
+		if e != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(e, "state: Failed to load client certificate"),
+			}
+			return
+		}
+
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	if tlsData.Pin != "" {
+		pin, e := ParsePin(tlsData.Pin)
+		if e != nil {
+			err = e
+			return
+		}
+
+		// With a CA bundle the chain and hostname are verified before the
+		// pin is checked, without a CA bundle the pin replaces chain
+		// verification for servers with a self-signed certificate
+		verifyHost := false
+		if tlsData.CaPath == "" {
+			tlsConf.InsecureSkipVerify = true
+			verifyHost = true
+		}
+
+		tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte,
+			_ [][]*x509.Certificate) (err error) {
+
+			if len(rawCerts) == 0 {
+				err = &errortypes.RequestError{
+					errors.New("state: Server did not provide certificate"),
+				}
+				return
+			}
+
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				err = &errortypes.RequestError{
+					errors.Wrap(err, "state: Failed to parse certificate"),
+				}
+				return
+			}
+
+			if verifyHost {
+				err = cert.VerifyHostname(host)
+				if err != nil {
+					err = &errortypes.RequestError{
+						errors.Wrap(err,
+							"state: Server certificate hostname mismatch"),
+					}
+					return
+				}
+			}
+
+			certPin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if subtle.ConstantTimeCompare(certPin[:], pin) != 1 {
+				err = &errortypes.RequestError{
+					errors.New("state: Server public key pin mismatch"),
+				}
+				return
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+func GetClient(uri string) (client *http.Client, err error) {
+	uriData := config.Config.GetUri(uri)
+	if uriData == nil {
+		uriData = &config.UriData{}
+	}
+
+	tlsData := uriData.Tls
+	if tlsData.IsEmpty() && uriData.Proxy == "" && uriData.Timeout == 0 {
+		if config.Config.SkipVerify {
+			client = ClientInsec
+		} else {
+			client = ClientSec
+		}
+		return
+	}
+
+	if tlsData == nil {
+		tlsData = &config.UriTlsData{}
+	}
+
+	timeout := 10 * time.Second
+	if uriData.Timeout != 0 {
+		timeout = uriData.GetTimeout()
+	}
+
+	key := fmt.Sprintf("%s&%d", getTlsKey(tlsData), timeout)
+
+	clientsLock.Lock()
+	cached := clients[uri]
+	clientsLock.Unlock()
+
+	if cached != nil && cached.key == key {
+		client = cached.client
+		return
+	}
+
+	parsedUri, err := url.ParseRequestURI(uri)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "state: Failed to parse uri"),
+		}
+		return
+	}
+
+	tlsConf, err := getTlsConfig(tlsData, parsedUri.Hostname())
+	if err != nil {
+		return
+	}
+
+	transport := proxy.NewTransport(uri)
+	transport.DisableKeepAlives = true
+	transport.TLSClientConfig = tlsConf
+
+	client = &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+
+	clientsLock.Lock()
+	clients[uri] = &uriClient{
+		key:    key,
+		client: client,
+	}
+	clientsLock.Unlock()
+
+	return
+}
@@ -2,11 +2,13 @@ package state
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
@@ -17,43 +19,52 @@ import (
 	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/constants"
 	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/proxy"
 	"github.com/pritunl/pritunl-link/utils"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	transport = &http.Transport{
-		DisableKeepAlives: true,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
 	ClientInsec = &http.Client{
-		Transport: transport,
-		Timeout:   10 * time.Second,
+		Transport: newTransport(&tls.Config{
+			InsecureSkipVerify: true,
+		}),
+		Timeout: 10 * time.Second,
 	}
 	ClientSec = &http.Client{
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-		},
-		Timeout: 10 * time.Second,
+		Transport: newTransport(nil),
+		Timeout:   10 * time.Second,
 	}
 	stateCaches = map[string]*stateCache{}
+	backoffs    = map[string]*backoff{}
+	pendingUris = set.NewSet()
+	cacheLock   sync.Mutex
 	Hash        = ""
 )
 
+func newTransport(tlsConf *tls.Config) (transport *http.Transport) {
+	transport = proxy.NewTransport("")
+	transport.DisableKeepAlives = true
+	transport.TLSClientConfig = tlsConf
+	return
+}
+
 type stateData struct {
-	Version       string            `json:"version"`
-	PublicAddress string            `json:"public_address"`
-	LocalAddress  string            `json:"local_address"`
-	Address6      string            `json:"address6"`
-	Status        map[string]string `json:"status"`
-	Errors        []string          `json:"errors"`
+	Version        string            `json:"version"`
+	PublicAddress  string            `json:"public_address"`
+	LocalAddress   string            `json:"local_address"`
+	Address6       string            `json:"address6"`
+	PublicAddress6 string            `json:"public_address6"`
+	Status         map[string]string `json:"status"`
+	Errors         []string          `json:"errors"`
+	Reports        []*Report         `json:"reports"`
+	Drain          bool              `json:"drain"`
+	Cached         bool              `json:"cached"`
+	Capabilities   []string          `json:"capabilities"`
 }
 
 type stateCache struct {
@@ -66,7 +77,7 @@ func decResp(secret, iv, sig, encData string) (cipData []byte, err error) {
 	hashFunc.Write([]byte(encData))
 	rawSignature := hashFunc.Sum(nil)
 	testSig := base64.StdEncoding.EncodeToString(rawSignature)
-	if sig != testSig {
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(testSig)) != 1 {
 		err = &errortypes.ParseError{
 			errors.Wrap(err, "state: Cipher data signature invalid"),
 		}
@@ -123,17 +134,54 @@ func decResp(secret, iv, sig, encData string) (cipData []byte, err error) {
 	return
 }
 
+// State used when the server is unreachable, the last state is kept
+// until it exceeds the max staleness then the disk cache is used
 func getStateCache(uri string) (state *State) {
+	cacheLock.Lock()
 	cache, ok := stateCaches[uri]
-	if ok && time.Since(cache.Timestamp) < constants.StateCacheTtl {
-		state = cache.State
-		return
+	cacheLock.Unlock()
+
+	if ok {
+		age := time.Since(cache.Timestamp)
+
+		if age < constants.StateCacheTtl {
+			state = cache.State
+			return
+		}
+
+		if age < getMaxStaleness() {
+			cacheLock.Lock()
+			_, exists := cachedUris[uri]
+			cachedUris[uri] = cache.Timestamp
+			cacheLock.Unlock()
+
+			if !exists {
+				logrus.WithFields(logrus.Fields{
+					"state_id":  cache.State.Id,
+					"cache_age": utils.ToFixed(age.Hours(), 2),
+				}).Warn("state: Server unreachable, running from cached state")
+			}
+
+			state = cache.State
+			return
+		}
 	}
 
+	state = getDiskCache(uri)
+
 	return
 }
 
-func GetState(uri string) (state *State, err error) {
+func GetState(ctx context.Context, uri string,
+	linkStatus map[string]string) (state *State, err error) {
+
+	state, err = getState(ctx, uri, linkStatus, true)
+	return
+}
+
+func getState(ctx context.Context, uri string,
+	linkStatus map[string]string, retry bool) (state *State, err error) {
+
 	if constants.Interrupt {
 		err = &errortypes.UnknownError{
 			errors.Wrap(err, "state: Interrupt"),
@@ -151,12 +199,24 @@ func GetState(uri string) (state *State, err error) {
 		return
 	}
 
+	reps := GetReports()
+
+	cacheLock.Lock()
+	_, cached := cachedUris[uri]
+	cacheLock.Unlock()
+
 	data := &stateData{
-		Version:       constants.Version,
-		PublicAddress: GetPublicAddress(),
-		LocalAddress:  GetLocalAddress(),
-		Address6:      GetAddress6(),
-		Status:        Status[uriData.User.Username()],
+		Version:        constants.Version,
+		PublicAddress:  GetPublicAddress(),
+		LocalAddress:   GetLocalAddress(),
+		Address6:       GetAddress6(),
+		PublicAddress6: PublicAddress6,
+		Status:         linkStatus,
+		Errors:         getErrors(reps),
+		Reports:        reps,
+		Drain:          config.Config.Drain,
+		Cached:         cached,
+		Capabilities:   Capabilities,
 	}
 	dataBuf := &bytes.Buffer{}
 
@@ -180,36 +240,26 @@ func GetState(uri string) (state *State, err error) {
 		return
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	timeout := constants.StateRequestTimeout
+	if uriConf := config.Config.GetUri(uri); uriConf != nil &&
+		uriConf.Timeout != 0 {
 
-	hostId := uriData.User.Username()
-	hostSecret, _ := uriData.User.Password()
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-	nonce := utils.RandStr(32)
+		timeout = uriConf.GetTimeout()
+	}
 
-	authStr := strings.Join([]string{
-		hostId,
-		timestamp,
-		nonce,
-		"PUT",
-		"/link/state",
-	}, "&")
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req = req.WithContext(reqCtx)
 
-	hashFunc := hmac.New(sha512.New, []byte(hostSecret))
-	hashFunc.Write([]byte(authStr))
-	rawSignature := hashFunc.Sum(nil)
-	sig := base64.StdEncoding.EncodeToString(rawSignature)
+	req.Header.Set("Content-Type", "application/json")
 
-	req.Header.Set("Auth-Token", hostId)
-	req.Header.Set("Auth-Timestamp", timestamp)
-	req.Header.Set("Auth-Nonce", nonce)
-	req.Header.Set("Auth-Signature", sig)
+	hostId := uriData.User.Username()
+	hostSecret, _ := uriData.User.Password()
+	reqNonce := AuthRequest(req, uriData)
 
-	var client *http.Client
-	if config.Config.SkipVerify {
-		client = ClientInsec
-	} else {
-		client = ClientSec
+	client, err := GetClient(uri)
+	if err != nil {
+		return
 	}
 
 	start := time.Now()
@@ -230,25 +280,47 @@ func GetState(uri string) (state *State, err error) {
 			}
 		} else {
 			err = nil
+			failBackoff(uri)
 		}
 		return
 	}
 	defer res.Body.Close()
 
+	rawOffset := getDateOffset(res, start)
+	dateOffset := boundOffset(rawOffset, constants.StateClockOffsetMax)
+
+	if res.StatusCode != 200 {
+		warnClockSkew(uriData.Host, rawOffset)
+	}
+
 	if res.StatusCode >= 500 && res.StatusCode < 600 {
 		state = getStateCache(uri)
 		if state == nil {
 			err = &errortypes.RequestError{
-				errors.Wrapf(err, "state: Bad status %n code from server",
+				errors.Wrapf(err, "state: Bad status %d code from server",
 					res.StatusCode),
 			}
 		} else {
 			err = nil
+			failBackoff(uri)
 		}
 		return
-	} else if res.StatusCode != 200 {
+	} else if retry && (res.StatusCode == 401 || res.StatusCode == 403) {
+		prevOffset, changed := setProvisionalOffset(uriData.Host, rawOffset)
+		if changed {
+			res.Body.Close()
+
+			state, err = getState(ctx, uri, linkStatus, false)
+			if err != nil {
+				restoreClockOffset(uriData.Host, prevOffset)
+			}
+			return
+		}
+	}
+
+	if res.StatusCode != 200 {
 		err = &errortypes.RequestError{
-			errors.Wrapf(err, "state: Bad status %n code from server",
+			errors.Wrapf(err, "state: Bad status %d code from server",
 				res.StatusCode),
 		}
 		return
@@ -262,12 +334,52 @@ func GetState(uri string) (state *State, err error) {
 		return
 	}
 
-	decBody, err := decResp(
-		hostSecret,
-		res.Header.Get("Cipher-IV"),
-		res.Header.Get("Cipher-Signature"),
-		string(body),
-	)
+	var decBody []byte
+	authenticated := false
+	cipherMode := res.Header.Get("Cipher-Mode")
+	if cipherMode != "" {
+		decBody, err = decRespAead(
+			uriData.Host,
+			hostId,
+			hostSecret,
+			cipherMode,
+			res.Header.Get("Cipher-Nonce"),
+			res.Header.Get("Cipher-Timestamp"),
+			reqNonce,
+			string(body),
+			dateOffset,
+		)
+		authenticated = true
+	} else if config.Config.DisableLegacyCipher {
+		err = &errortypes.ParseError{
+			errors.New("state: Server response uses disabled legacy cipher"),
+		}
+	} else {
+		// Older servers do not sign the response timestamp and nonce
+		if res.Header.Get("Auth-Signature") != "" {
+			err = checkRespAuth(
+				uriData.Host,
+				hostId,
+				hostSecret,
+				res.Header.Get("Auth-Timestamp"),
+				res.Header.Get("Auth-Nonce"),
+				reqNonce,
+				res.Header.Get("Auth-Signature"),
+				res.Header.Get("Cipher-Signature"),
+				dateOffset,
+			)
+			authenticated = true
+		}
+
+		if err == nil {
+			decBody, err = decResp(
+				hostSecret,
+				res.Header.Get("Cipher-IV"),
+				res.Header.Get("Cipher-Signature"),
+				string(body),
+			)
+		}
+	}
 	if err != nil {
 		err = &errortypes.RequestError{
 			errors.Wrap(err, "state: Failed to decrypt response"),
@@ -275,6 +387,10 @@ func GetState(uri string) (state *State, err error) {
 		return
 	}
 
+	if authenticated {
+		updateClockOffset(uriData.Host, rawOffset)
+	}
+
 	err = json.Unmarshal(decBody, state)
 	if err != nil {
 		err = &errortypes.ParseError{
@@ -287,36 +403,181 @@ func GetState(uri string) (state *State, err error) {
 		Timestamp: time.Now(),
 		State:     state,
 	}
+	resetBackoff(uri)
+
+	cacheLock.Lock()
 	stateCaches[uri] = cache
+	_, wasCached := cachedUris[uri]
+	delete(cachedUris, uri)
+	if state.HasCapability(CapNotify) {
+		notifyUris[uri] = state.Hash
+	} else {
+		delete(notifyUris, uri)
+	}
+	cacheLock.Unlock()
+
+	if wasCached {
+		logrus.WithFields(logrus.Fields{
+			"state_id": state.Id,
+		}).Info("state: Server reachable, running from live state")
+	}
+
+	err = saveDiskCache(uri, state)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("state: Failed to save state cache")
+		err = nil
+	}
 
 	return
 }
 
-func GetStates() (states []*State) {
+type stateResult struct {
+	index int
+	state *State
+}
+
+// Requests that exceed the collect timeout continue in the background and
+// update the state cache, the cached state is used until they complete
+func GetStates(ctx context.Context) (states []*State) {
 	states = []*State{}
-	uris := config.Config.Uris
+	uris := config.Config.GetUris()
 	urisSet := set.NewSet()
-
-	for _, uri := range uris {
+	hostsSet := set.NewSet()
+	results := make([]*State, len(uris))
+	received := make([]bool, len(uris))
+	resultsChan := make(chan stateResult, len(uris))
+	pending := 0
+	stats := GetStatus()
+
+	for i, uri := range uris {
 		urisSet.Add(uri)
+		if uriData, err := url.ParseRequestURI(uri); err == nil {
+			hostsSet.Add(uriData.Host)
+		}
+
+		if checkBackoff(uri) {
+			results[i] = getStateCache(uri)
+			received[i] = true
+			continue
+		}
+
+		cacheLock.Lock()
+		inflight := pendingUris.Contains(uri)
+		if !inflight {
+			pendingUris.Add(uri)
+		}
+		cacheLock.Unlock()
 
-		state, err := GetState(uri)
-		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"uri":   uri,
-				"error": err,
-			}).Info("state: Failed to get state")
+		if inflight {
+			results[i] = getStateCache(uri)
+			received[i] = true
 			continue
 		}
 
-		states = append(states, state)
+		pending += 1
+		var linkStatus map[string]string
+		uriData, err := url.ParseRequestURI(uri)
+		if err == nil {
+			linkStatus = stats[uriData.User.Username()]
+		}
+
+		go func(i int, uri string) {
+			defer func() {
+				cacheLock.Lock()
+				pendingUris.Remove(uri)
+				cacheLock.Unlock()
+			}()
+
+			state, err := GetState(ctx, uri, linkStatus)
+			if err != nil {
+				delay := failBackoff(uri)
+
+				logrus.WithFields(logrus.Fields{
+					"uri":     uri,
+					"backoff": utils.ToFixed(delay.Seconds(), 2),
+					"error":   err,
+				}).Info("state: Failed to get state")
+			}
+
+			resultsChan <- stateResult{
+				index: i,
+				state: state,
+			}
+		}(i, uri)
 	}
 
+	timeout := time.NewTimer(constants.StateCollectTimeout)
+	defer timeout.Stop()
+
+collect:
+	for pending > 0 {
+		select {
+		case result := <-resultsChan:
+			results[result.index] = result.state
+			received[result.index] = true
+			pending -= 1
+		case <-timeout.C:
+			break collect
+		}
+	}
+
+	for i, uri := range uris {
+		if !received[i] {
+			results[i] = getStateCache(uri)
+		}
+	}
+
+	for _, state := range results {
+		if state != nil {
+			states = append(states, state)
+		}
+	}
+
+	cacheLock.Lock()
 	for uri := range stateCaches {
 		if !urisSet.Contains(uri) {
 			delete(stateCaches, uri)
 		}
 	}
+	for uri := range backoffs {
+		if !urisSet.Contains(uri) {
+			delete(backoffs, uri)
+		}
+	}
+	for uri := range notifyUris {
+		if !urisSet.Contains(uri) {
+			delete(notifyUris, uri)
+		}
+	}
+	for uri := range cachedUris {
+		if !urisSet.Contains(uri) {
+			delete(cachedUris, uri)
+		}
+	}
+	removeUris := []string{}
+	for uri := range diskCacheHashes {
+		if !urisSet.Contains(uri) {
+			removeUris = append(removeUris, uri)
+		}
+	}
+	cacheLock.Unlock()
+
+	for _, uri := range removeUris {
+		clearDiskCache(uri)
+	}
+
+	cleanClockOffsets(hostsSet)
+
+	cached := GetCachedUris()
+	if len(cached) != 0 {
+		SetError("state_cache", SeverityWarning, fmt.Sprintf(
+			"Running from cached state for %d of %d servers",
+			len(cached), len(uris)), nil)
+	} else {
+		ClearError("state_cache")
+	}
 
 	return
 }
@@ -0,0 +1,132 @@
+// Lifecycle of background loops bound to a root context.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+const restartDelay = 3 * time.Second
+
+type Supervisor struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiter  sync.WaitGroup
+	lock    sync.Mutex
+	running map[string]int
+}
+
+func New(parent context.Context) (sup *Supervisor) {
+	ctx, cancel := context.WithCancel(parent)
+
+	sup = &Supervisor{
+		ctx:     ctx,
+		cancel:  cancel,
+		running: map[string]int{},
+	}
+
+	return
+}
+
+func (s *Supervisor) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Supervisor) run(name string, fn func(ctx context.Context)) (
+	panicked bool) {
+
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			panicked = true
+
+			logrus.WithFields(logrus.Fields{
+				"task":  name,
+				"panic": fmt.Sprintf("%v", rec),
+			}).Error("supervisor: Task panicked")
+		}
+	}()
+
+	fn(s.ctx)
+
+	return
+}
+
+// Run fn until the supervisor is stopped, fn must return when the context
+// is done. Tasks that return early are restarted.
+func (s *Supervisor) Go(name string, fn func(ctx context.Context)) {
+	s.lock.Lock()
+	s.running[name] += 1
+	s.lock.Unlock()
+
+	s.waiter.Add(1)
+
+	go func() {
+		defer func() {
+			s.lock.Lock()
+			s.running[name] -= 1
+			if s.running[name] <= 0 {
+				delete(s.running, name)
+			}
+			s.lock.Unlock()
+
+			s.waiter.Done()
+		}()
+
+		for {
+			panicked := s.run(name, fn)
+
+			if s.ctx.Err() != nil {
+				return
+			}
+
+			if !panicked {
+				logrus.WithFields(logrus.Fields{
+					"task": name,
+				}).Error("supervisor: Task exited unexpectedly")
+			}
+
+			if !utils.Sleep(s.ctx, restartDelay) {
+				return
+			}
+		}
+	}()
+}
+
+// Cancel all tasks and wait for them to return
+func (s *Supervisor) Stop(timeout time.Duration) (err error) {
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.waiter.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		break
+	case <-time.After(timeout):
+		s.lock.Lock()
+		names := []string{}
+		for name := range s.running {
+			names = append(names, name)
+		}
+		s.lock.Unlock()
+		sort.Strings(names)
+
+		err = &errortypes.UnknownError{
+			errors.Newf("supervisor: Tasks did not stop %v", names),
+		}
+		return
+	}
+
+	return
+}
@@ -0,0 +1,147 @@
+package sync
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-link/config"
+	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/ipsec"
+	"github.com/pritunl/pritunl-link/state"
+	"net"
+	"path/filepath"
+)
+
+var defaultLocalExclude = []string{
+	"lo",
+	"docker*",
+	"br-*",
+	"veth*",
+	"virbr*",
+	"cni*",
+	"flannel*",
+	"cali*",
+	"kube*",
+	"tun*",
+	"tap*",
+	"wg*",
+	"ip_vti*",
+	"pritunl*",
+}
+
+var ulaNetwork = &net.IPNet{
+	IP:   net.ParseIP("fc00::"),
+	Mask: net.CIDRMask(7, 128),
+}
+
+func isLocalExcluded(name string) bool {
+	if name == ipsec.DirectIface {
+		return true
+	}
+
+	patterns := append([]string{}, config.Config.LocalExclude...)
+	if !config.Config.DisableDefaultLocalExclude {
+		patterns = append(patterns, defaultLocalExclude...)
+	}
+
+	for _, pattern := range patterns {
+		match, _ := filepath.Match(pattern, name)
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getLocalIfaces() (ifaces []net.Interface, err error) {
+	allIfaces, err := net.Interfaces()
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "sync: Failed to get interfaces"),
+		}
+		return
+	}
+
+	ifaces = []net.Interface{}
+
+	pin := config.Config.LocalInterface
+	if pin != "" {
+		for _, iface := range allIfaces {
+			if iface.Name == pin {
+				ifaces = append(ifaces, iface)
+				break
+			}
+		}
+
+		if len(ifaces) == 0 {
+			err = &errortypes.NotFoundError{
+				errors.Newf("sync: Local interface '%s' not found", pin),
+			}
+			return
+		}
+
+		return
+	}
+
+	// Default interface is preferred, remaining interfaces follow in
+	// index order
+	defaultIface := state.GetDefaultInterface()
+	for _, iface := range allIfaces {
+		if iface.Name == defaultIface && !isLocalExcluded(iface.Name) {
+			ifaces = append(ifaces, iface)
+			break
+		}
+	}
+
+	for _, iface := range allIfaces {
+		if iface.Name == defaultIface || iface.Flags&net.FlagUp == 0 ||
+			iface.Flags&net.FlagLoopback != 0 ||
+			isLocalExcluded(iface.Name) {
+
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+
+	return
+}
+
+func selectLocalAddress() (addr, addr6 string, err error) {
+	ifaces, err := getLocalIfaces()
+	if err != nil {
+		return
+	}
+
+	for _, iface := range ifaces {
+		addrs, e := iface.Addrs()
+		if e != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() ||
+				ipnet.IP.IsLinkLocalUnicast() {
+
+				continue
+			}
+
+			if ipnet.IP.To4() != nil {
+				if addr == "" {
+					addr = ipnet.IP.String()
+				}
+			} else if !ulaNetwork.Contains(ipnet.IP) &&
+				ipnet.IP.IsGlobalUnicast() {
+
+				if addr6 == "" {
+					addr6 = ipnet.IP.String()
+				}
+			}
+		}
+
+		if addr != "" && addr6 != "" {
+			break
+		}
+	}
+
+	return
+}
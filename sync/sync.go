@@ -1,51 +1,103 @@
 package sync
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/dropbox/godropbox/container/set"
-	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/pritunl-link/config"
 	"github.com/pritunl/pritunl-link/constants"
-	"github.com/pritunl/pritunl-link/errortypes"
+	"github.com/pritunl/pritunl-link/discover"
 	"github.com/pritunl/pritunl-link/ipsec"
+	"github.com/pritunl/pritunl-link/network"
 	"github.com/pritunl/pritunl-link/state"
+	"github.com/pritunl/pritunl-link/status"
+	"github.com/pritunl/pritunl-link/supervisor"
 	"github.com/pritunl/pritunl-link/utils"
 	"io"
-	"net"
-	"net/http"
-	"strings"
+	"reflect"
 	"time"
 )
 
+const (
+	reloadStates    = "states"
+	reloadAdvertise = "advertise"
+	reloadDeploy    = "deploy"
+	reloadTunnel    = "tunnel"
+	reloadNetwork   = "network"
+	reloadDrain     = "drain"
+)
+
 var (
-	client = &http.Client{
-		Timeout: 30 * time.Second,
-	}
-	curMod time.Time
+	curMod        time.Time
+	Reload        = make(chan bool, 1)
+	resyncNetwork = make(chan bool, 1)
 )
 
-type publicAddressData struct {
-	Ip string `json:"ip"`
+// Work required when a config field changes, fields that are read on use
+// do not need an action
+var reloadActions = map[string]string{
+	"uris":                          reloadStates,
+	"proxy":                         reloadStates,
+	"no_proxy":                      reloadStates,
+	"skip_verify":                   reloadStates,
+	"disable_legacy_cipher":         reloadStates,
+	"provider":                      reloadAdvertise,
+	"disable_advertise_update":      reloadAdvertise,
+	"aws":                           reloadAdvertise,
+	"google":                        reloadAdvertise,
+	"oracle":                        reloadAdvertise,
+	"unifi":                         reloadAdvertise,
+	"firewall":                      reloadDeploy,
+	"default_interface":             reloadDeploy,
+	"default_gateway":               reloadDeploy,
+	"public_address":                reloadDeploy,
+	"local_address":                 reloadDeploy,
+	"address6":                      reloadDeploy,
+	"direct_ssh":                    reloadDeploy,
+	"direct_exempt":                 reloadDeploy,
+	"disable_default_exempt":        reloadDeploy,
+	"delete_routes":                 reloadDeploy,
+	"direct_mode":                   reloadTunnel,
+	"direct_subnet":                 reloadTunnel,
+	"subnet_conflict":               reloadDeploy,
+	"static_links":                  reloadStates,
+	"local_interface":               reloadNetwork,
+	"local_exclude":                 reloadNetwork,
+	"disable_default_local_exclude": reloadNetwork,
+	"public_address_sources":        reloadNetwork,
+	"public_address_consensus":      reloadNetwork,
+	"drain":                         reloadDrain,
 }
 
-func SyncStates() {
+func SyncStates(ctx context.Context) {
 	if constants.Interrupt {
 		return
 	}
 
-	states := state.GetStates()
+	states := state.GetStates(ctx)
+	states = append(states, state.GetStaticStates()...)
 	hsh := md5.New()
 
 	names := set.NewSet()
 	for _, stat := range states {
+		io.WriteString(hsh, stat.Hash)
+
+		// Static peers are not managed by a Pritunl server and may be
+		// down for long periods, a full restart would not recover them
+		if stat.Type == state.Static {
+			continue
+		}
+
 		for i := range stat.Links {
-			names.Add(fmt.Sprintf("%s-%d", stat.Id, i))
+			name := fmt.Sprintf("%s-%d", stat.Id, i)
+			if state.IsConflict(name) {
+				continue
+			}
+			names.Add(name)
 		}
-		io.WriteString(hsh, stat.Hash)
 	}
 
 	newHash := hex.EncodeToString(hsh.Sum(nil))
@@ -65,6 +117,8 @@ func SyncStates() {
 		}).Info("sync: Failed to get status")
 	}
 
+	ipsec.UpdateDirect()
+
 	if resetLinks != nil && len(resetLinks) != 0 {
 		logrus.Warn("sync: Disconnected timeout restarting")
 
@@ -87,38 +141,52 @@ func SyncStates() {
 	return
 }
 
-func runSyncStates() {
+func statusChanged() bool {
+	stats, _, err := status.Get()
+	if err != nil {
+		return true
+	}
+	state.AddConflictStatus(stats)
+
+	return !reflect.DeepEqual(
+		map[string]map[string]string(stats), state.GetStatus())
+}
+
+func runSyncStates(ctx context.Context) {
+	lastSync := time.Now()
+
 	for {
-		time.Sleep(1 * time.Second)
-		SyncStates()
+		select {
+		case <-ctx.Done():
+			return
+		case <-state.Notify:
+			logrus.Info("sync: State change notify received")
+			break
+		case <-time.After(1 * time.Second):
+			if state.IsNotifyAll() &&
+				time.Since(lastSync) < constants.StateHeartbeatRate &&
+				!statusChanged() {
+
+				continue
+			}
+			break
+		}
+
+		lastSync = time.Now()
+		SyncStates(ctx)
 	}
 }
 
 func SyncDefaultIface(redeploy bool) (err error) {
-	if constants.Interrupt || state.IsDirectClient {
+	if constants.Interrupt {
 		return
 	}
 
-	output, err := utils.ExecCombinedOutput("", "route", "-n")
+	defaultIface, defaultGateway, err := network.GetDefaultRoute()
 	if err != nil {
 		return
 	}
 
-	defaultIface := ""
-	defaultGateway := ""
-	outputLines := strings.Split(output, "\n")
-	for _, line := range outputLines {
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			continue
-		}
-
-		if fields[0] == "0.0.0.0" {
-			defaultIface = strings.TrimSpace(fields[len(fields)-1])
-			defaultGateway = strings.TrimSpace(fields[1])
-		}
-	}
-
 	if defaultIface == ipsec.DirectIface {
 		return
 	}
@@ -136,9 +204,7 @@ func SyncDefaultIface(redeploy bool) (err error) {
 			ipsec.Redeploy()
 		}
 	} else if config.Config.DefaultInterface == "" {
-		logrus.WithFields(logrus.Fields{
-			"output": output,
-		}).Warn("sync: Failed to find default interface")
+		logrus.Warn("sync: Failed to find default interface")
 	}
 
 	if defaultGateway != "" {
@@ -154,26 +220,12 @@ func SyncDefaultIface(redeploy bool) (err error) {
 			ipsec.Redeploy()
 		}
 	} else if config.Config.DefaultGateway == "" {
-		logrus.WithFields(logrus.Fields{
-			"output": output,
-		}).Warn("sync: Failed to find default gateway")
+		logrus.Warn("sync: Failed to find default gateway")
 	}
 
 	return
 }
 
-func runSyncDefaultIface() {
-	for {
-		time.Sleep(5 * time.Second)
-		err := SyncDefaultIface(true)
-		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Info("sync: Failed to get default interface")
-		}
-	}
-}
-
 func SyncLocalAddress(redeploy bool) (err error) {
 	if constants.Interrupt || state.IsDirectClient {
 		return
@@ -181,57 +233,40 @@ func SyncLocalAddress(redeploy bool) (err error) {
 
 	changed := false
 
-	addrs, err := net.InterfaceAddrs()
+	localAddress, address6, err := selectLocalAddress()
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "sync: Failed to get interface addresses"),
-		}
 		return
 	}
 
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				localAddress := ipnet.IP.String()
-				curLocalAddress := state.LocalAddress
-
-				if curLocalAddress != localAddress {
-					changed = true
-				}
-				state.LocalAddress = localAddress
+	if localAddress != "" {
+		curLocalAddress := state.LocalAddress
 
-				if changed && redeploy {
-					logrus.WithFields(logrus.Fields{
-						"old_local_address": curLocalAddress,
-						"local_address":     localAddress,
-					}).Info("sync: Local address changed redeploying")
-				}
+		if curLocalAddress != localAddress {
+			changed = true
+		}
+		state.LocalAddress = localAddress
 
-				break
-			}
+		if changed && redeploy {
+			logrus.WithFields(logrus.Fields{
+				"old_local_address": curLocalAddress,
+				"local_address":     localAddress,
+			}).Info("sync: Local address changed redeploying")
 		}
 	}
 
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() == nil {
-				address6 := ipnet.IP.String()
-				curAddress6 := state.Address6
+	if address6 != "" {
+		curAddress6 := state.Address6
 
-				if curAddress6 != address6 {
-					changed = true
-				}
-				state.Address6 = address6
-
-				if changed && redeploy {
-					logrus.WithFields(logrus.Fields{
-						"old_address6": curAddress6,
-						"address6":     address6,
-					}).Info("sync: Address6 changed redeploying")
-				}
+		if curAddress6 != address6 {
+			changed = true
+		}
+		state.Address6 = address6
 
-				break
-			}
+		if changed && redeploy {
+			logrus.WithFields(logrus.Fields{
+				"old_address6": curAddress6,
+				"address6":     address6,
+			}).Info("sync: Address6 changed redeploying")
 		}
 	}
 
@@ -242,9 +277,52 @@ func SyncLocalAddress(redeploy bool) (err error) {
 	return
 }
 
-func runSyncLocalAddress() {
-	for {
-		time.Sleep(5 * time.Second)
+func SyncPublicAddress(ctx context.Context, redeploy bool) (err error) {
+	if constants.Interrupt || state.IsDirectClient {
+		return
+	}
+
+	publicAddress, publicAddress6, err := discover.Discover(ctx)
+	if err != nil {
+		state.SetError("public_address", state.SeverityWarning,
+			"Public address discovery failed", err)
+		return
+	}
+	state.ClearError("public_address")
+
+	if state.IsDirectClient {
+		return
+	}
+
+	curPublicAddress := state.PublicAddress
+
+	state.PublicAddress = publicAddress
+	state.PublicAddress6 = publicAddress6
+
+	// Public IPv6 address is only reported, links use the local address6
+	if curPublicAddress != publicAddress && redeploy {
+		logrus.WithFields(logrus.Fields{
+			"old_public_address": curPublicAddress,
+			"public_address":     publicAddress,
+		}).Info("sync: Public address changed redeploying")
+
+		ipsec.Redeploy()
+	}
+
+	return
+}
+
+func syncNetwork(ctx context.Context, iface, local, public bool) {
+	if iface {
+		err := SyncDefaultIface(true)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Info("sync: Failed to get default interface")
+		}
+	}
+
+	if local {
 		err := SyncLocalAddress(true)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
@@ -252,86 +330,127 @@ func runSyncLocalAddress() {
 			}).Info("sync: Failed to get local address")
 		}
 	}
-}
 
-func SyncPublicAddress(redeploy bool) (err error) {
-	if constants.Interrupt || state.IsDirectClient {
-		return
+	if public {
+		err := SyncPublicAddress(ctx, true)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Info("sync: Failed to get public address")
+		}
 	}
 
-	req, err := http.NewRequest(
-		"GET",
-		constants.PublicIpServer,
-		nil,
-	)
-	if err != nil {
-		err = &errortypes.RequestError{
-			errors.Wrap(err, "sync: Failed to get public address"),
+	ipsec.UpdateDirect()
+}
+
+func runSyncNetwork(ctx context.Context) {
+	changed := network.Watch(ctx)
+	lastLocal := time.Now()
+	lastPublic := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			logrus.Info("sync: Network change detected")
+
+			// Public address discovery sends requests to external
+			// services and is rate limited, a change that is not
+			// covered here is picked up by the next poll
+			syncPublic := time.Since(lastPublic) >=
+				constants.PublicAddressPollRate
+
+			syncNetwork(ctx, true, true, syncPublic)
+			lastLocal = time.Now()
+			if syncPublic {
+				lastPublic = time.Now()
+			}
+
+			break
+		case <-resyncNetwork:
+			syncNetwork(ctx, true, true, true)
+			lastLocal = time.Now()
+			lastPublic = time.Now()
+
+			break
+		case <-time.After(1 * time.Second):
+			// Netlink events are the primary trigger, polling remains as
+			// a safety net for missed events
+			localRate := constants.NetworkPollRate
+			if network.IsWatching() {
+				localRate = constants.NetworkSlowPollRate
+			}
+
+			syncLocal := time.Since(lastLocal) >= localRate
+			syncPublic := time.Since(lastPublic) >=
+				constants.PublicAddressPollRate
+
+			if syncLocal || syncPublic {
+				syncNetwork(ctx, syncLocal, syncLocal, syncPublic)
+			}
+			if syncLocal {
+				lastLocal = time.Now()
+			}
+			if syncPublic {
+				lastPublic = time.Now()
+			}
+
+			break
 		}
-		return
 	}
+}
 
-	req.Header.Set("User-Agent", "pritunl-link")
-
-	res, err := client.Do(req)
+func reloadConfig() (err error) {
+	changed, err := config.Reload()
 	if err != nil {
-		err = &errortypes.RequestError{
-			errors.Wrap(err, "sync: Failed to get public address"),
-		}
 		return
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		err = &errortypes.RequestError{
-			errors.Wrapf(err, "sync: Bad status %n code from server",
-				res.StatusCode),
-		}
+	if len(changed) == 0 {
 		return
 	}
 
-	data := &publicAddressData{}
+	logrus.WithFields(logrus.Fields{
+		"changed": changed,
+	}).Info("sync: Reloaded config")
 
-	err = json.NewDecoder(res.Body).Decode(data)
-	if err != nil {
-		err = &errortypes.ParseError{
-			errors.Wrap(err, "sync: Failed to parse data"),
+	actions := map[string]bool{}
+	for _, name := range changed {
+		action := reloadActions[name]
+		if action != "" {
+			actions[action] = true
 		}
-		return
 	}
 
-	if data.Ip != "" && !state.IsDirectClient {
-		publicAddress := data.Ip
-		curPublicAddress := state.PublicAddress
-
-		state.PublicAddress = publicAddress
-
-		if curPublicAddress != publicAddress && redeploy {
-			logrus.WithFields(logrus.Fields{
-				"old_public_address": curPublicAddress,
-				"public_address":     publicAddress,
-			}).Info("sync: Public address changed redeploying")
-
-			ipsec.Redeploy()
+	if actions[reloadNetwork] {
+		select {
+		case resyncNetwork <- true:
+		default:
 		}
 	}
 
-	return
-}
-
-func runSyncPublicAddress() {
-	for {
-		time.Sleep(30 * time.Second)
-		err := SyncPublicAddress(true)
-		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Info("sync: Failed to get public address")
+	if actions[reloadStates] || actions[reloadDrain] {
+		select {
+		case state.Notify <- true:
+		default:
 		}
 	}
+
+	if actions[reloadTunnel] {
+		ipsec.RebuildTunnel()
+	} else if actions[reloadDeploy] {
+		ipsec.Redeploy()
+	} else if actions[reloadDrain] {
+		ipsec.UpdateDrain()
+	} else if actions[reloadAdvertise] {
+		ipsec.UpdateAdvertise()
+	}
+
+	return
 }
 
-func SyncConfig() (err error) {
+func SyncConfig(ctx context.Context) (err error) {
 	if constants.Interrupt {
 		return
 	}
@@ -342,63 +461,83 @@ func SyncConfig() (err error) {
 	}
 
 	if mod != curMod {
-		time.Sleep(5 * time.Second)
-
-		mod, err = config.GetModTime()
-		if err != nil {
+		if !utils.Sleep(ctx, 5*time.Second) {
 			return
 		}
 
-		err = config.Load()
+		mod, err = config.GetModTime()
 		if err != nil {
 			return
 		}
-
-		logrus.Info("Reloaded config")
-
 		curMod = mod
 
-		ipsec.Redeploy()
+		err = reloadConfig()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("sync: Invalid config, keeping current config")
+			err = nil
+		}
 	}
 
 	return
 }
 
-func runSyncConfig() {
+func runSyncConfig(ctx context.Context) {
 	curMod, _ = config.GetModTime()
 
 	for {
-		time.Sleep(500 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return
+		case <-Reload:
+			logrus.Info("sync: Config reload requested")
 
-		err := SyncConfig()
-		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-			}).Info("sync: Failed to sync config")
+			curMod, _ = config.GetModTime()
+
+			err := reloadConfig()
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+				}).Error("sync: Invalid config, keeping current config")
+			}
+
+			break
+		case <-time.After(500 * time.Millisecond):
+			err := SyncConfig(ctx)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+				}).Info("sync: Failed to sync config")
+			}
+
+			break
 		}
 	}
 }
 
-func Init() {
+func Start(sup *supervisor.Supervisor) {
+	ctx := sup.Context()
+
 	err := SyncDefaultIface(false)
 	if err != nil {
-		time.Sleep(5 * time.Second)
+		utils.Sleep(ctx, 5*time.Second)
 		SyncDefaultIface(false)
 	}
 	err = SyncLocalAddress(false)
 	if err != nil {
-		time.Sleep(5 * time.Second)
+		utils.Sleep(ctx, 5*time.Second)
 		SyncLocalAddress(false)
 	}
-	err = SyncPublicAddress(false)
+	err = SyncPublicAddress(ctx, false)
 	if err != nil {
-		time.Sleep(10 * time.Second)
-		SyncPublicAddress(false)
-	}
-	SyncStates()
-	go runSyncDefaultIface()
-	go runSyncLocalAddress()
-	go runSyncPublicAddress()
-	go runSyncStates()
-	go runSyncConfig()
+		utils.Sleep(ctx, 10*time.Second)
+		SyncPublicAddress(ctx, false)
+	}
+	SyncStates(ctx)
+
+	sup.Go("sync.network", runSyncNetwork)
+	sup.Go("sync.states", runSyncStates)
+	sup.Go("sync.notify", state.RunNotify)
+	sup.Go("sync.config", runSyncConfig)
 }
@@ -1,12 +1,14 @@
 package utils
 
 import (
+	"context"
 	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/pritunl-link/errortypes"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"time"
 )
 
 func Exec(dir, name string, arg ...string) (err error) {
@@ -29,6 +31,29 @@ func Exec(dir, name string, arg ...string) (err error) {
 	return
 }
 
+// Exec that is killed when the context is done
+func ExecContext(ctx context.Context, dir, name string, arg ...string) (
+	err error) {
+
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if dir != "" {
+		cmd.Dir = dir
+	}
+
+	err = cmd.Run()
+	if err != nil {
+		err = &errortypes.ExecError{
+			errors.Wrapf(err, "utils: Failed to exec '%s'", name),
+		}
+		return
+	}
+
+	return
+}
+
 func ExecInput(dir, input, name string, arg ...string) (err error) {
 	cmd := exec.Command(name, arg...)
 	cmd.Stdout = os.Stdout
@@ -136,3 +161,13 @@ func ExecSilent(dir, name string, arg ...string) (err error) {
 
 	return
 }
+
+// Sleep for duration, returns false if the context finished first
+func Sleep(ctx context.Context, duration time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}